@@ -2,8 +2,11 @@ package main
 
 import (
         "log"
+        "log/slog"
         "net/http"
+        "os"
 
+        "github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/metrics"
         "github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/middleware"
         "github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
         "github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
@@ -19,19 +22,76 @@ func main() {
         // Load token limits from environment variables
         cfg.LoadTokenLimitsFromEnv()
 
-        // Initialize Redis storage
-        addr, password, db := middleware.LoadRedisConfig()
-        store, err := storage.NewRedisStorage(addr, password, db)
+        // Initialize storage: in memory for single-instance deployments, Redis otherwise
+        var store storage.Storage
+        if os.Getenv("RATE_LIMIT_STORAGE") == "memory" {
+                store = storage.NewMemoryStorage(middleware.LoadMemoryConfig())
+        } else {
+                store, err = storage.NewRedisStorageWithOptions(middleware.LoadRedisOptions(),
+                        storage.WithKeyPrefix(middleware.LoadRedisKeyPrefix()))
+                if err != nil {
+                        log.Fatal(err)
+                }
+        }
+        defer store.Close()
+
+        // Don't let a hanging storage hold every request
+        timeout, err := middleware.LoadStorageTimeout()
         if err != nil {
                 log.Fatal(err)
         }
-        defer store.Close()
+        if timeout > 0 {
+                store = storage.NewTimeoutStorage(store, timeout)
+        }
+
+        // Record decisions and storage latency, exposed on /metrics
+        rateLimitMetrics := metrics.New(metrics.Options{})
+        store = rateLimitMetrics.InstrumentStorage(store)
 
         // Create rate limiter
-        limiter := ratelimiter.New(store, cfg)
+        logger := slog.Default()
+        limiter := rateLimitMetrics.InstrumentLimiter(ratelimiter.New(store, cfg, ratelimiter.WithLogger(logger)))
+
+        // Decide what happens to requests while the storage is unavailable
+        failurePolicy, err := middleware.LoadFailurePolicy()
+        if err != nil {
+                log.Fatal(err)
+        }
+
+        // Only trust forwarding headers set by our own proxies
+        trustedProxies, err := middleware.LoadTrustedProxies()
+        if err != nil {
+                log.Fatal(err)
+        }
+
+        // Read the client IP from the header our proxies set
+        clientIPHeader, err := middleware.LoadClientIPHeader()
+        if err != nil {
+                log.Fatal(err)
+        }
+
+        // Group client IPs into subnets, e.g. one key per IPv6 /64
+        subnets, err := middleware.LoadSubnetConfig()
+        if err != nil {
+                log.Fatal(err)
+        }
+
+        // Give expensive routes limits of their own
+        routes, err := middleware.LoadRouteRules()
+        if err != nil {
+                log.Fatal(err)
+        }
 
         // Create middleware
-        rateLimiterMiddleware := middleware.New(limiter, cfg)
+        rateLimiterMiddleware := middleware.New(limiter, cfg,
+                middleware.WithTrustedProxies(trustedProxies...),
+                middleware.WithClientIPHeader(clientIPHeader),
+                middleware.WithSubnets(subnets),
+                middleware.WithRoutes(routes...),
+                middleware.WithFailurePolicy(failurePolicy),
+                middleware.WithLogger(logger),
+        )
+        defer rateLimiterMiddleware.Close()
 
         // Create a simple handler
         handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -40,6 +100,7 @@ func main() {
 
         // Wrap the handler with the rate limiter middleware
         http.Handle("/", rateLimiterMiddleware.Handler(handler))
+        http.Handle("/metrics", rateLimitMetrics.Handler())
 
         // Start the server
         log.Println("Server starting on :8080")
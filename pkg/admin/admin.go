@@ -0,0 +1,259 @@
+// Package admin serves an HTTP API to inspect and manage rate limited keys:
+// check a key's status, block, unblock or reset it, and list blocked keys.
+//
+// The API changes who gets rate limited, so mount it on an internal address
+// or behind authentication, never next to the public routes.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/middleware"
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+)
+
+// DefaultListLimit is the number of blocked keys listed when the request
+// doesn't set one
+const DefaultListLimit = 100
+
+// Limiter is the part of a *ratelimiter.RateLimiter the API manages keys with
+type Limiter interface {
+	Status(ctx context.Context, req ratelimiter.Request) (ratelimiter.KeyStatus, error)
+	Block(ctx context.Context, req ratelimiter.Request, duration time.Duration) error
+	Unblock(ctx context.Context, req ratelimiter.Request) error
+	Reset(ctx context.Context, req ratelimiter.Request) error
+	ListBlocked(ctx context.Context, limit int) ([]ratelimiter.BlockedKey, error)
+}
+
+// KeyStatus is the response of GET /keys/{key}
+type KeyStatus struct {
+	Key               string  `json:"key"`
+	Rule              string  `json:"rule"`
+	Limit             int     `json:"limit"`
+	WindowSeconds     float64 `json:"window_seconds"`
+	Count             int     `json:"count"`
+	Remaining         int     `json:"remaining"`
+	ResetAfterSeconds float64 `json:"reset_after_seconds"`
+	Blocked           bool    `json:"blocked"`
+	BlockTTLSeconds   float64 `json:"block_ttl_seconds"`
+}
+
+// BlockedKey is an item of the response of GET /blocked. Key, Type and Rule
+// select the key in the key routes, unless Hashed reports that Key is the
+// hash of the client key, which the routes need instead.
+type BlockedKey struct {
+	Key        string  `json:"key"`
+	Type       string  `json:"type,omitempty"`
+	Rule       string  `json:"rule,omitempty"`
+	Hashed     bool    `json:"hashed,omitempty"`
+	StorageKey string  `json:"storage_key"`
+	TTLSeconds float64 `json:"ttl_seconds"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewHandler returns the API handler. Its routes are:
+//
+//	GET    /keys/{key}         status of a key
+//	PUT    /keys/{key}/block   block a key for ?duration=, e.g. 10m, or for
+//	                           the block duration of its limit
+//	DELETE /keys/{key}/block   unblock a key
+//	DELETE /keys/{key}/count   reset the counters of a key, keeping its block
+//	GET    /blocked            blocked keys, up to ?limit= (default 100)
+//
+// Key routes take the client IP or token as {key}, ?type=ip (default) or
+// ?type=token, and an optional ?rule= selecting a rule of Config.Rules.
+// Client IPs are grouped into subnets as the middleware does, so that
+// 2001:db8::1 refers to the key of 2001:db8::/64; subnet keys in CIDR form
+// are used as they are. Mount the handler under a prefix with
+// http.StripPrefix.
+func NewHandler(limiter Limiter, opts ...Option) http.Handler {
+	h := &handler{limiter: limiter}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /keys/{key}", h.status)
+	mux.HandleFunc("PUT /keys/{key}/block", h.block)
+	mux.HandleFunc("DELETE /keys/{key}/block", h.unblock)
+	mux.HandleFunc("DELETE /keys/{key}/count", h.reset)
+	mux.HandleFunc("GET /blocked", h.listBlocked)
+	return mux
+}
+
+// Option configures optional API behavior
+type Option func(*handler)
+
+// WithSubnets groups client IPs into subnets like a middleware configured
+// with middleware.WithSubnets(subnets). Without it, the middleware's default
+// prefixes apply.
+func WithSubnets(subnets middleware.SubnetConfig) Option {
+	return func(h *handler) {
+		h.subnets = subnets
+	}
+}
+
+type handler struct {
+	limiter Limiter
+
+	// subnets groups client IPs into the subnets the middleware limits
+	subnets middleware.SubnetConfig
+}
+
+func (h *handler) status(w http.ResponseWriter, r *http.Request) {
+	req, err := h.parseRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	status, err := h.limiter.Status(r.Context(), req)
+	if err != nil {
+		writeLimiterError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, KeyStatus{
+		Key:               status.Key,
+		Rule:              status.Rule,
+		Limit:             status.Limit.MaxRequests,
+		WindowSeconds:     status.Limit.Window.Seconds(),
+		Count:             status.Count,
+		Remaining:         status.Remaining,
+		ResetAfterSeconds: status.ResetAfter.Seconds(),
+		Blocked:           status.Blocked(),
+		BlockTTLSeconds:   status.BlockTTL.Seconds(),
+	})
+}
+
+func (h *handler) block(w http.ResponseWriter, r *http.Request) {
+	req, err := h.parseRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var duration time.Duration
+	if s := r.URL.Query().Get("duration"); s != "" {
+		duration, err = time.ParseDuration(s)
+		if err != nil || duration <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration %q", s))
+			return
+		}
+	}
+
+	if err := h.limiter.Block(r.Context(), req, duration); err != nil {
+		writeLimiterError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) unblock(w http.ResponseWriter, r *http.Request) {
+	req, err := h.parseRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.limiter.Unblock(r.Context(), req); err != nil {
+		writeLimiterError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) reset(w http.ResponseWriter, r *http.Request) {
+	req, err := h.parseRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.limiter.Reset(r.Context(), req); err != nil {
+		writeLimiterError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) listBlocked(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultListLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", s))
+			return
+		}
+		limit = n
+	}
+
+	blocked, err := h.limiter.ListBlocked(r.Context(), limit)
+	if err != nil {
+		writeLimiterError(w, err)
+		return
+	}
+
+	keys := make([]BlockedKey, len(blocked))
+	for i, b := range blocked {
+		keys[i] = BlockedKey{
+			Key:        b.Request.Key,
+			Rule:       b.Request.Rule,
+			Hashed:     b.Hashed,
+			StorageKey: b.StorageKey,
+			TTLSeconds: b.TTL.Seconds(),
+		}
+		if b.Request.Key != "" {
+			keys[i].Type = ratelimiter.KeyTypeIP
+			if b.Request.IsToken {
+				keys[i].Type = ratelimiter.KeyTypeToken
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// parseRequest builds the rate limit request a key route refers to
+func (h *handler) parseRequest(r *http.Request) (ratelimiter.Request, error) {
+	query := r.URL.Query()
+	req := ratelimiter.Request{Key: r.PathValue("key"), Rule: query.Get("rule")}
+
+	switch keyType := query.Get("type"); keyType {
+	case "", ratelimiter.KeyTypeIP:
+		req.Key = h.subnets.IPKey(req.Key, req.Rule)
+	case ratelimiter.KeyTypeToken:
+		req.IsToken = true
+	default:
+		return req, fmt.Errorf("unknown key type %q", keyType)
+	}
+	return req, nil
+}
+
+// writeLimiterError responds with an error of the limiter. Errors of the
+// request are client errors; storage errors are server errors.
+func writeLimiterError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, ratelimiter.ErrUnknownRule) || errors.Is(err, ratelimiter.ErrNoBlockDuration) {
+		status = http.StatusBadRequest
+	}
+	writeError(w, status, err)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
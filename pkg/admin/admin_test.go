@@ -0,0 +1,195 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/middleware"
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
+)
+
+func newTestAPI(t *testing.T) (*ratelimiter.RateLimiter, http.Handler) {
+	t.Helper()
+
+	store := storage.NewMemoryStorage(storage.MemoryOptions{})
+	t.Cleanup(func() { store.Close() })
+	config := &ratelimiter.Config{
+		MaxRequestsPerSecond: 2,
+		BlockDuration:        time.Minute,
+		Rules:                map[string]ratelimiter.TokenConfig{"export": {MaxRequestsPerSecond: 1}},
+	}
+	limiter := ratelimiter.New(store, config)
+	return limiter, NewHandler(limiter)
+}
+
+func serve(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func TestStatusRoute(t *testing.T) {
+	limiter, h := newTestAPI(t)
+	if _, err := limiter.Allow(t.Context(), ratelimiter.Request{Key: "abc", IsToken: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(h, http.MethodGet, "/keys/abc?type=token")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /keys/abc status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var status KeyStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	want := KeyStatus{
+		Key:               "token:abc",
+		Rule:              ratelimiter.RuleDefault,
+		Limit:             2,
+		WindowSeconds:     1,
+		Count:             1,
+		Remaining:         1,
+		ResetAfterSeconds: status.ResetAfterSeconds,
+	}
+	if status != want {
+		t.Errorf("GET /keys/abc = %+v, want %+v", status, want)
+	}
+}
+
+func TestBlockUnblockAndList(t *testing.T) {
+	_, h := newTestAPI(t)
+
+	if rec := serve(h, http.MethodPut, "/keys/10.0.0.1/block?duration=10m&rule=export"); rec.Code != http.StatusNoContent {
+		t.Fatalf("PUT block status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	rec := serve(h, http.MethodGet, "/blocked")
+	var blocked []BlockedKey
+	if err := json.NewDecoder(rec.Body).Decode(&blocked); err != nil {
+		t.Fatal(err)
+	}
+	if len(blocked) != 1 || blocked[0].TTLSeconds <= 540 {
+		t.Fatalf("GET /blocked = %+v, want one key blocked for 10 minutes", blocked)
+	}
+	want := BlockedKey{
+		Key:        "10.0.0.1",
+		Type:       ratelimiter.KeyTypeIP,
+		Rule:       "export",
+		StorageKey: "ip/export:10.0.0.1",
+		TTLSeconds: blocked[0].TTLSeconds,
+	}
+	if blocked[0] != want {
+		t.Errorf("GET /blocked = %+v, want %+v", blocked[0], want)
+	}
+
+	// The listed key selects the key in the key routes
+	target := "/keys/" + blocked[0].Key + "/block?type=" + blocked[0].Type + "&rule=" + blocked[0].Rule
+	if rec := serve(h, http.MethodDelete, target); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE block status = %d, body = %s", rec.Code, rec.Body)
+	}
+	rec = serve(h, http.MethodGet, "/blocked")
+	if body := rec.Body.String(); body != "[]\n" {
+		t.Errorf("GET /blocked after unblocking = %s, want []", body)
+	}
+}
+
+func TestResetRoute(t *testing.T) {
+	limiter, h := newTestAPI(t)
+	req := ratelimiter.Request{Key: "10.0.0.1", Rule: "export"}
+	if _, err := limiter.Allow(t.Context(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := serve(h, http.MethodDelete, "/keys/10.0.0.1/count?rule=export"); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE count status = %d, body = %s", rec.Code, rec.Body)
+	}
+	status, err := limiter.Status(t.Context(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Count != 0 {
+		t.Errorf("count after reset = %d, want 0", status.Count)
+	}
+}
+
+func TestIPKeysMatchMiddlewareSubnets(t *testing.T) {
+	store := storage.NewMemoryStorage(storage.MemoryOptions{})
+	defer store.Close()
+	config := &ratelimiter.Config{MaxRequestsPerSecond: 2, BlockDuration: time.Minute}
+	subnets := middleware.SubnetConfig{Levels: []middleware.SubnetLevel{
+		{IPv6: true, Prefix: 48, Limit: ratelimiter.TokenConfig{MaxRequestsPerSecond: 100, BlockDuration: time.Minute}},
+	}}
+	limiter := ratelimiter.New(store, config)
+	app := middleware.New(limiter, config, middleware.WithSubnets(subnets)).Handler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h := NewHandler(limiter, WithSubnets(subnets))
+
+	request := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "[2001:db8::1]:1234"
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for i := 0; i < 3; i++ {
+		request()
+	}
+
+	// The client IP refers to the key of its /64, like the CIDR form
+	for _, target := range []string{"/keys/2001:db8::1", "/keys/2001:db8::%2F64"} {
+		var status KeyStatus
+		if err := json.NewDecoder(serve(h, http.MethodGet, target).Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		if status.Key != "ip:2001:db8::/64" || !status.Blocked {
+			t.Errorf("GET %s = %+v, want the blocked key of 2001:db8::/64", target, status)
+		}
+	}
+
+	// Subnet level rules use the prefix of their level
+	var status KeyStatus
+	if err := json.NewDecoder(serve(h, http.MethodGet, "/keys/2001:db8::1?rule=ipv6/48").Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Key != "ip/ipv6/48:2001:db8::/48" || status.Count != 2 {
+		t.Errorf("GET with the level rule = %+v, want the /48 key counted twice", status)
+	}
+
+	// Unblocking another address of the subnet unblocks the client
+	if rec := serve(h, http.MethodDelete, "/keys/2001:db8::2/block"); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE block status = %d, body = %s", rec.Code, rec.Body)
+	}
+	serve(h, http.MethodDelete, "/keys/2001:db8::2/count")
+	if code := request(); code != http.StatusOK {
+		t.Errorf("Request after unblocking status = %d, want 200", code)
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	_, h := newTestAPI(t)
+
+	for _, tc := range []struct {
+		method, target string
+	}{
+		{http.MethodGet, "/keys/abc?type=user"},
+		{http.MethodGet, "/keys/abc?rule=missing"},
+		{http.MethodPut, "/keys/abc/block?duration=soon"},
+		{http.MethodPut, "/keys/abc/block?duration=-1m"},
+		{http.MethodPut, "/keys/abc/block?rule=export"}, // no block duration
+		{http.MethodGet, "/blocked?limit=0"},
+	} {
+		rec := serve(h, tc.method, tc.target)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s %s status = %d, want 400", tc.method, tc.target, rec.Code)
+			continue
+		}
+		var body errorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error == "" {
+			t.Errorf("%s %s body has no error message", tc.method, tc.target)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+)
+
+// limiter records the decisions of another limiter
+type limiter struct {
+	ratelimiter.RateLimiterInterface
+	metrics *Metrics
+}
+
+// InstrumentLimiter wraps a limiter so that its decisions are recorded. Pass
+// the wrapped limiter to middleware.New.
+func (m *Metrics) InstrumentLimiter(l ratelimiter.RateLimiterInterface) ratelimiter.RateLimiterInterface {
+	return &limiter{RateLimiterInterface: l, metrics: m}
+}
+
+// HasRule reports whether the wrapped limiter knows a rule, or true when it
+// can't tell
+func (l *limiter) HasRule(name string) bool {
+	rules, ok := l.RateLimiterInterface.(interface{ HasRule(string) bool })
+	return !ok || rules.HasRule(name)
+}
+
+func (l *limiter) IsAllowed(ctx context.Context, key string, isToken bool) (bool, error) {
+	decision, err := l.Allow(ctx, ratelimiter.Request{Key: key, IsToken: isToken})
+	if err != nil {
+		return false, err
+	}
+	return decision.Allowed, nil
+}
+
+func (l *limiter) Allow(ctx context.Context, req ratelimiter.Request) (ratelimiter.Decision, error) {
+	decision, err := l.RateLimiterInterface.Allow(ctx, req)
+	if err == nil {
+		l.metrics.ObserveDecision(decision)
+	}
+	return decision, err
+}
@@ -0,0 +1,320 @@
+// Package metrics collects rate limiter metrics and exposes them in the
+// Prometheus text exposition format, without depending on a Prometheus
+// client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the storage latency
+// histogram buckets: from 0.5ms, a fast Redis round trip, up to one second
+var DefaultBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// maxTrackedBlocks is the number of blocks tracked for the active blocks gauge
+// past which expired ones are removed without waiting for a scrape
+const maxTrackedBlocks = 10000
+
+// Options configures Metrics
+type Options struct {
+	// Namespace prefixes every metric name (default: "ratelimiter")
+	Namespace string
+
+	// Buckets are the upper bounds, in seconds, of the storage latency
+	// histogram buckets (default: DefaultBuckets)
+	Buckets []float64
+}
+
+// Metrics counts rate limit decisions and storage calls. Record them by
+// wrapping the limiter with InstrumentLimiter and the storage with
+// InstrumentStorage, and expose them with Handler.
+//
+// The exported metrics are:
+//
+//	ratelimiter_decisions_total{outcome, key_type, rule}  counter
+//	ratelimiter_blocks_total{key_type, rule}              counter
+//	ratelimiter_active_blocks                             gauge
+//	ratelimiter_storage_duration_seconds{method}          histogram
+//	ratelimiter_storage_errors_total{method}              counter
+//
+// Active blocks are the blocks seen by this instance that have not expired.
+type Metrics struct {
+	namespace string
+	buckets   []float64
+
+	mu        sync.Mutex
+	decisions map[decisionLabels]uint64
+	blocks    map[blockLabels]uint64
+	blocked   map[string]time.Time // block expiry by storage key
+	durations map[string]*histogram
+	errors    map[string]uint64
+}
+
+type decisionLabels struct {
+	outcome ratelimiter.Reason
+	keyType string
+	rule    string
+}
+
+type blockLabels struct {
+	keyType string
+	rule    string
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// New creates an empty set of metrics
+func New(opts Options) *Metrics {
+	if opts.Namespace == "" {
+		opts.Namespace = "ratelimiter"
+	}
+	if len(opts.Buckets) == 0 {
+		opts.Buckets = DefaultBuckets
+	}
+	buckets := append([]float64(nil), opts.Buckets...)
+	sort.Float64s(buckets)
+
+	return &Metrics{
+		namespace: opts.Namespace,
+		buckets:   buckets,
+		decisions: make(map[decisionLabels]uint64),
+		blocks:    make(map[blockLabels]uint64),
+		blocked:   make(map[string]time.Time),
+		durations: make(map[string]*histogram),
+		errors:    make(map[string]uint64),
+	}
+}
+
+// ObserveDecision records a rate limit decision. Requests that exceed a limit
+// and block their key also count as a block.
+func (m *Metrics) ObserveDecision(decision ratelimiter.Decision) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.decisions[decisionLabels{outcome: decision.Reason, keyType: decision.KeyType, rule: decision.Rule}]++
+
+	if decision.BlockedUntil.After(now) {
+		if decision.Reason == ratelimiter.ReasonLimitExceeded {
+			m.blocks[blockLabels{keyType: decision.KeyType, rule: decision.Rule}]++
+		}
+		if _, ok := m.blocked[decision.Key]; !ok && len(m.blocked) >= maxTrackedBlocks {
+			m.removeExpiredBlocks(now)
+		}
+		m.blocked[decision.Key] = decision.BlockedUntil
+	}
+}
+
+// ObserveStorageCall records the duration and outcome of a call to a storage
+// method
+func (m *Metrics) ObserveStorageCall(method string, duration time.Duration, err error) {
+	seconds := duration.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h := m.durations[method]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.durations[method] = h
+	}
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+
+	if err != nil {
+		m.errors[method]++
+	}
+}
+
+// Handler serves the metrics in the Prometheus text exposition format, e.g.
+// on /metrics
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteTo(w)
+	})
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	// Copy the metrics so that a slow client doesn't hold up the limiter
+	snap := m.snapshot()
+	b := &writer{w: bufio.NewWriter(w)}
+
+	name := m.namespace + "_decisions_total"
+	b.header(name, "counter", "Rate limit decisions by outcome, key type and rule.")
+	decisions := sortedKeys(snap.decisions, func(l decisionLabels) string {
+		return labels("key_type", l.keyType, "outcome", string(l.outcome), "rule", l.rule)
+	})
+	for _, l := range decisions {
+		b.sample(name, labels("key_type", l.keyType, "outcome", string(l.outcome), "rule", l.rule), float64(snap.decisions[l]))
+	}
+
+	name = m.namespace + "_blocks_total"
+	b.header(name, "counter", "Keys blocked for exceeding a limit, by key type and rule.")
+	blocks := sortedKeys(snap.blocks, func(l blockLabels) string {
+		return labels("key_type", l.keyType, "rule", l.rule)
+	})
+	for _, l := range blocks {
+		b.sample(name, labels("key_type", l.keyType, "rule", l.rule), float64(snap.blocks[l]))
+	}
+
+	name = m.namespace + "_active_blocks"
+	b.header(name, "gauge", "Blocks seen by this instance that have not expired.")
+	b.sample(name, "", float64(snap.activeBlocks))
+
+	name = m.namespace + "_storage_duration_seconds"
+	b.header(name, "histogram", "Duration of storage calls by method.")
+	for _, method := range sortedKeys(snap.durations, identity) {
+		h := snap.durations[method]
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += h.counts[i]
+			b.sample(name+"_bucket", labels("method", method, "le", formatFloat(bound)), float64(cumulative))
+		}
+		b.sample(name+"_bucket", labels("method", method, "le", "+Inf"), float64(h.count))
+		b.sample(name+"_sum", labels("method", method), h.sum)
+		b.sample(name+"_count", labels("method", method), float64(h.count))
+	}
+
+	name = m.namespace + "_storage_errors_total"
+	b.header(name, "counter", "Failed storage calls by method.")
+	for _, method := range sortedKeys(snap.errors, identity) {
+		b.sample(name, labels("method", method), float64(snap.errors[method]))
+	}
+
+	if b.err == nil {
+		b.err = b.w.Flush()
+	}
+	return b.n, b.err
+}
+
+// snapshot is a copy of the metrics taken by WriteTo
+type snapshot struct {
+	decisions    map[decisionLabels]uint64
+	blocks       map[blockLabels]uint64
+	activeBlocks int
+	durations    map[string]histogram
+	errors       map[string]uint64
+}
+
+// snapshot copies the metrics under the lock
+func (m *Metrics) snapshot() snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.removeExpiredBlocks(time.Now())
+	snap := snapshot{
+		decisions:    maps.Clone(m.decisions),
+		blocks:       maps.Clone(m.blocks),
+		activeBlocks: len(m.blocked),
+		durations:    make(map[string]histogram, len(m.durations)),
+		errors:       maps.Clone(m.errors),
+	}
+	for method, h := range m.durations {
+		snap.durations[method] = histogram{counts: slices.Clone(h.counts), sum: h.sum, count: h.count}
+	}
+	return snap
+}
+
+// removeExpiredBlocks forgets the blocks that expired by now. The caller must
+// hold m.mu.
+func (m *Metrics) removeExpiredBlocks(now time.Time) {
+	for key, until := range m.blocked {
+		if !until.After(now) {
+			delete(m.blocked, key)
+		}
+	}
+}
+
+// writer writes the text exposition format and remembers the first error
+type writer struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (b *writer) printf(format string, args ...any) {
+	if b.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(b.w, format, args...)
+	b.n += int64(n)
+	b.err = err
+}
+
+func (b *writer) header(name, kind, help string) {
+	b.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (b *writer) sample(name, labels string, value float64) {
+	b.printf("%s%s %s\n", name, labels, formatFloat(value))
+}
+
+// labels formats name and value pairs as a label set, e.g. {method="Block"}
+func labels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(pairs[i])
+		sb.WriteString(`="`)
+		sb.WriteString(escaper.Replace(pairs[i+1]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// escaper escapes label values as the text exposition format requires
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of m sorted by the string returned by key, so
+// that the output is stable between scrapes
+func sortedKeys[K comparable, V any](m map[K]V, key func(K) string) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return key(keys[i]) < key(keys[j])
+	})
+	return keys
+}
+
+func identity(s string) string {
+	return s
+}
@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
+)
+
+// scrape returns the body served by the metrics handler
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the Prometheus text format", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func assertContains(t *testing.T, body string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Metrics missing %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestInstrumentLimiter(t *testing.T) {
+	m := New(Options{})
+	store := storage.NewMemoryStorage(storage.MemoryOptions{})
+	defer store.Close()
+
+	config := &ratelimiter.Config{
+		MaxRequestsPerSecond: 2,
+		BlockDuration:        time.Minute,
+		TokenLimits:          map[string]ratelimiter.TokenConfig{"abc": {MaxRequestsPerSecond: 10}},
+	}
+	limiter := m.InstrumentLimiter(ratelimiter.New(m.InstrumentStorage(store), config))
+
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		if _, err := limiter.Allow(ctx, ratelimiter.Request{Key: "10.0.0.1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := limiter.IsAllowed(ctx, "abc", true); err != nil {
+		t.Fatal(err)
+	}
+
+	body := scrape(t, m)
+	assertContains(t, body,
+		"# TYPE ratelimiter_decisions_total counter",
+		`ratelimiter_decisions_total{key_type="ip",outcome="allowed",rule="default"} 2`,
+		`ratelimiter_decisions_total{key_type="ip",outcome="limit_exceeded",rule="default"} 1`,
+		`ratelimiter_decisions_total{key_type="ip",outcome="blocked",rule="default"} 1`,
+		`ratelimiter_decisions_total{key_type="token",outcome="allowed",rule="token"} 1`,
+		`ratelimiter_blocks_total{key_type="ip",rule="default"} 1`,
+		"# TYPE ratelimiter_active_blocks gauge",
+		"ratelimiter_active_blocks 1",
+		"# TYPE ratelimiter_storage_duration_seconds histogram",
+		`ratelimiter_storage_duration_seconds_bucket{method="CheckAndIncrement",le="+Inf"} 5`,
+		`ratelimiter_storage_duration_seconds_count{method="CheckAndIncrement"} 5`,
+	)
+}
+
+func TestInstrumentLimiterForwardsHasRule(t *testing.T) {
+	config := &ratelimiter.Config{Rules: map[string]ratelimiter.TokenConfig{"checkout": {MaxRequestsPerSecond: 1}}}
+	limiter := New(Options{}).InstrumentLimiter(ratelimiter.New(nil, config))
+
+	rules, ok := limiter.(interface{ HasRule(string) bool })
+	if !ok {
+		t.Fatal("Instrumented limiter has no HasRule method")
+	}
+	if !rules.HasRule("checkout") || rules.HasRule("missing") {
+		t.Error("HasRule() should report the rules of the wrapped limiter")
+	}
+}
+
+func TestObserveStorageCall(t *testing.T) {
+	m := New(Options{Namespace: "api", Buckets: []float64{0.1, 0.01}})
+
+	m.ObserveStorageCall("Block", 5*time.Millisecond, nil)
+	m.ObserveStorageCall("Block", 50*time.Millisecond, nil)
+	m.ObserveStorageCall("Block", time.Second, errors.New("timeout"))
+
+	body := scrape(t, m)
+	assertContains(t, body,
+		`api_storage_duration_seconds_bucket{method="Block",le="0.01"} 1`,
+		`api_storage_duration_seconds_bucket{method="Block",le="0.1"} 2`,
+		`api_storage_duration_seconds_bucket{method="Block",le="+Inf"} 3`,
+		`api_storage_duration_seconds_sum{method="Block"} 1.055`,
+		`api_storage_duration_seconds_count{method="Block"} 3`,
+		`api_storage_errors_total{method="Block"} 1`,
+	)
+}
+
+func TestActiveBlocksExpire(t *testing.T) {
+	m := New(Options{})
+	m.ObserveDecision(ratelimiter.Decision{
+		Reason:       ratelimiter.ReasonLimitExceeded,
+		Key:          "ip:10.0.0.1",
+		KeyType:      ratelimiter.KeyTypeIP,
+		Rule:         ratelimiter.RuleDefault,
+		BlockedUntil: time.Now().Add(20 * time.Millisecond),
+	})
+	assertContains(t, scrape(t, m), "ratelimiter_active_blocks 1")
+
+	time.Sleep(30 * time.Millisecond)
+	assertContains(t, scrape(t, m),
+		"ratelimiter_active_blocks 0",
+		`ratelimiter_blocks_total{key_type="ip",rule="default"} 1`,
+	)
+}
+
+// observingWriter records a storage call on every write, like a limiter
+// serving requests while a slow client scrapes the metrics
+type observingWriter struct {
+	m *Metrics
+}
+
+func (w observingWriter) Write(p []byte) (int, error) {
+	w.m.ObserveStorageCall("Write", time.Millisecond, nil)
+	return len(p), nil
+}
+
+func TestWriteToDoesNotHoldTheLock(t *testing.T) {
+	m := New(Options{})
+	// Enough methods for the output to outgrow the write buffer
+	for i := 0; i < 100; i++ {
+		m.ObserveStorageCall(fmt.Sprintf("Method%d", i), time.Millisecond, nil)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.WriteTo(observingWriter{m})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("WriteTo() holds the lock while writing")
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	if got, want := labels("rule", "a\"b\\c\nd"), `{rule="a\"b\\c\nd"}`; got != want {
+		t.Errorf("labels() = %s, want %s", got, want)
+	}
+}
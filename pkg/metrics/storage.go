@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
+)
+
+// instrumentedStorage records the duration and errors of the calls to
+// another Storage
+type instrumentedStorage struct {
+	backend storage.Storage
+	metrics *Metrics
+}
+
+// InstrumentStorage wraps a storage so that the duration and errors of its
+// calls are recorded by method
+func (m *Metrics) InstrumentStorage(backend storage.Storage) storage.Storage {
+	return &instrumentedStorage{backend: backend, metrics: m}
+}
+
+// observe records a call to method that started at start
+func (s *instrumentedStorage) observe(method string, start time.Time, err error) {
+	s.metrics.ObserveStorageCall(method, time.Since(start), err)
+}
+
+func (s *instrumentedStorage) GetRequestCount(ctx context.Context, key string) (int64, error) {
+	start := time.Now()
+	count, err := s.backend.GetRequestCount(ctx, key)
+	s.observe("GetRequestCount", start, err)
+	return count, err
+}
+
+func (s *instrumentedStorage) IncrementRequestCount(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	start := time.Now()
+	count, err := s.backend.IncrementRequestCount(ctx, key, expiration)
+	s.observe("IncrementRequestCount", start, err)
+	return count, err
+}
+
+func (s *instrumentedStorage) IncrByWithCost(ctx context.Context, key string, cost int64, expiration time.Duration) (int64, error) {
+	start := time.Now()
+	count, err := s.backend.IncrByWithCost(ctx, key, cost, expiration)
+	s.observe("IncrByWithCost", start, err)
+	return count, err
+}
+
+func (s *instrumentedStorage) CheckAndIncrement(ctx context.Context, key string, cost, limit int64, window, blockDuration time.Duration) (storage.Result, error) {
+	start := time.Now()
+	result, err := s.backend.CheckAndIncrement(ctx, key, cost, limit, window, blockDuration)
+	s.observe("CheckAndIncrement", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) TakeToken(ctx context.Context, key string, cost int64, rate float64, capacity int64) (storage.Result, error) {
+	start := time.Now()
+	result, err := s.backend.TakeToken(ctx, key, cost, rate, capacity)
+	s.observe("TakeToken", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) SlidingWindowLog(ctx context.Context, key string, cost, limit int64, window time.Duration) (storage.Result, error) {
+	start := time.Now()
+	result, err := s.backend.SlidingWindowLog(ctx, key, cost, limit, window)
+	s.observe("SlidingWindowLog", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) SlidingWindowCounter(ctx context.Context, key string, cost, limit int64, window time.Duration) (storage.Result, error) {
+	start := time.Now()
+	result, err := s.backend.SlidingWindowCounter(ctx, key, cost, limit, window)
+	s.observe("SlidingWindowCounter", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) IncrementQuotas(ctx context.Context, key string, cost int64, quotas []storage.Quota) (storage.Result, error) {
+	start := time.Now()
+	result, err := s.backend.IncrementQuotas(ctx, key, cost, quotas)
+	s.observe("IncrementQuotas", start, err)
+	return result, err
+}
+
+func (s *instrumentedStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	start := time.Now()
+	blocked, err := s.backend.IsBlocked(ctx, key)
+	s.observe("IsBlocked", start, err)
+	return blocked, err
+}
+
+func (s *instrumentedStorage) Block(ctx context.Context, key string, duration time.Duration) error {
+	start := time.Now()
+	err := s.backend.Block(ctx, key, duration)
+	s.observe("Block", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) GetBlockTTL(ctx context.Context, key string) (time.Duration, error) {
+	start := time.Now()
+	ttl, err := s.backend.GetBlockTTL(ctx, key)
+	s.observe("GetBlockTTL", start, err)
+	return ttl, err
+}
+
+func (s *instrumentedStorage) GetWindowTTL(ctx context.Context, key string) (time.Duration, error) {
+	start := time.Now()
+	ttl, err := s.backend.GetWindowTTL(ctx, key)
+	s.observe("GetWindowTTL", start, err)
+	return ttl, err
+}
+
+func (s *instrumentedStorage) Unblock(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.backend.Unblock(ctx, key)
+	s.observe("Unblock", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) Reset(ctx context.Context, key string, quotas []storage.Quota) error {
+	start := time.Now()
+	err := s.backend.Reset(ctx, key, quotas)
+	s.observe("Reset", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) ListBlocked(ctx context.Context, limit int) ([]storage.BlockedKey, error) {
+	start := time.Now()
+	blocked, err := s.backend.ListBlocked(ctx, limit)
+	s.observe("ListBlocked", start, err)
+	return blocked, err
+}
+
+func (s *instrumentedStorage) Close() error {
+	return s.backend.Close()
+}
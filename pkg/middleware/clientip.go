@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientIPHeader is the forwarding header trusted proxies report the client
+// IP in
+type ClientIPHeader string
+
+const (
+	// HeaderXForwardedFor reads the X-Forwarded-For header (default)
+	HeaderXForwardedFor ClientIPHeader = "X-Forwarded-For"
+
+	// HeaderForwarded reads the "for" parameters of the RFC 7239 Forwarded
+	// header
+	HeaderForwarded ClientIPHeader = "Forwarded"
+
+	// HeaderXRealIP reads the X-Real-IP header, which holds the client IP
+	// alone
+	HeaderXRealIP ClientIPHeader = "X-Real-IP"
+)
+
+// ParseClientIPHeader converts a header name such as "forwarded" into a
+// ClientIPHeader
+func ParseClientIPHeader(s string) (ClientIPHeader, error) {
+	s = strings.TrimSpace(s)
+	for _, header := range []ClientIPHeader{HeaderXForwardedFor, HeaderForwarded, HeaderXRealIP} {
+		if strings.EqualFold(s, string(header)) {
+			return header, nil
+		}
+	}
+	if s == "" {
+		return HeaderXForwardedFor, nil
+	}
+	return "", fmt.Errorf("unknown client IP header %q", s)
+}
+
+// WithTrustedProxies makes the middleware honor the forwarding header of
+// requests coming from the given proxies, X-Forwarded-For unless
+// WithClientIPHeader selects another. Without trusted proxies the client IP
+// is always the address of the connection.
+func WithTrustedProxies(proxies ...netip.Prefix) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.trustedProxies = append(m.trustedProxies, proxies...)
+	}
+}
+
+// WithClientIPHeader sets the forwarding header trusted proxies report the
+// client IP in. Only one header is read: a proxy that sets one header
+// usually passes the others through from the client, so honoring them would
+// let clients choose their own IP.
+func WithClientIPHeader(header ClientIPHeader) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.clientIPHeader = header
+	}
+}
+
+// ParseTrustedProxies parses a comma separated list of CIDRs, such as
+// "10.0.0.0/8, fd00::/8". Plain IP addresses are accepted as single hosts.
+func ParseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if strings.Contains(part, "/") {
+			prefix, err := netip.ParsePrefix(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", part, err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", part, err)
+		}
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
+// clientIP returns the client IP address of the request
+func (m *RateLimiterMiddleware) clientIP(r *http.Request) string {
+	return getClientIP(r, m.trustedProxies, m.clientIPHeader)
+}
+
+// getClientIP extracts the client IP address from the request.
+//
+// Forwarding headers can be set by anyone, so they are only used when the
+// connection comes from a trusted proxy, and only the header the proxies
+// are known to set is read. The forwarded addresses are walked from right
+// to left, skipping the trusted proxies each hop appended, and the first
+// untrusted address is the client.
+func getClientIP(r *http.Request, trusted []netip.Prefix, header ClientIPHeader) string {
+	remote := remoteHost(r.RemoteAddr)
+
+	addr, ok := parseHop(remote)
+	if !ok || !isTrusted(addr, trusted) {
+		return remote
+	}
+
+	var hops []string
+	switch header {
+	case HeaderForwarded:
+		hops = forwardedFor(r.Header.Values("Forwarded"))
+	case HeaderXRealIP:
+		if realIP, ok := parseHop(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ok {
+			return realIP.String()
+		}
+	default:
+		hops = forwardedList(r.Header.Values("X-Forwarded-For"))
+	}
+
+	client := addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHop(hops[i])
+		if !ok {
+			// Addresses left of an unusable hop can't be trusted either, so
+			// the last proxy that reported it is as far as we can go
+			break
+		}
+		client = hop
+		if !isTrusted(hop, trusted) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+// remoteHost strips the port from a RemoteAddr, which may be an IPv6
+// address in brackets
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// isTrusted reports whether addr belongs to a trusted proxy
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHop parses a forwarded address. Hops may carry a port, and IPv6
+// hops may be in brackets, such as "[2001:db8::1]:4711".
+func parseHop(s string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")); err == nil {
+		return addr.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// forwardedList splits X-Forwarded-For header values into hops, from the
+// client to the closest proxy
+func forwardedList(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// forwardedFor returns the "for" parameters of RFC 7239 Forwarded header
+// values, from the client to the closest proxy. Obfuscated identifiers and
+// "unknown" are kept so that they stop the walk over the hops.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(strings.TrimSpace(name), "for") {
+					hops = append(hops, strings.Trim(strings.TrimSpace(val), `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
@@ -0,0 +1,239 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type GetClientIPTestSuite struct {
+	suite.Suite
+	trusted []netip.Prefix
+}
+
+func (s *GetClientIPTestSuite) SetupTest() {
+	var err error
+	s.trusted, err = ParseTrustedProxies("10.0.0.0/8, fd00::/8")
+	s.Require().NoError(err)
+}
+
+func (s *GetClientIPTestSuite) TestGetClientIP() {
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		header     ClientIPHeader
+		remoteAddr string
+		want       string
+	}{
+		{
+			name: "X-Forwarded-For header",
+			headers: map[string]string{
+				"X-Forwarded-For": "192.168.1.1",
+			},
+			remoteAddr: "10.0.0.1:1234",
+			want:       "192.168.1.1",
+		},
+		{
+			name: "X-Real-IP header",
+			headers: map[string]string{
+				"X-Real-IP": "192.168.1.2",
+			},
+			header:     HeaderXRealIP,
+			remoteAddr: "10.0.0.1:1234",
+			want:       "192.168.1.2",
+		},
+		{
+			name: "X-Real-IP is ignored by default",
+			headers: map[string]string{
+				"X-Real-IP": "192.168.1.2",
+			},
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.1",
+		},
+		{
+			name: "X-Forwarded-For is ignored when reading X-Real-IP",
+			headers: map[string]string{
+				"X-Forwarded-For": "192.168.1.1",
+			},
+			header:     HeaderXRealIP,
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "RemoteAddr only",
+			headers:    map[string]string{},
+			remoteAddr: "192.168.1.3:1234",
+			want:       "192.168.1.3",
+		},
+		{
+			name: "Multiple IPs in X-Forwarded-For",
+			headers: map[string]string{
+				"X-Forwarded-For": "192.168.1.1, 10.0.0.1",
+			},
+			remoteAddr: "10.0.0.1:1234",
+			want:       "192.168.1.1",
+		},
+		{
+			name: "Spoofed X-Forwarded-For entries are skipped",
+			headers: map[string]string{
+				"X-Forwarded-For": "1.2.3.4, 203.0.113.7, 10.0.0.2",
+			},
+			remoteAddr: "10.0.0.1:1234",
+			want:       "203.0.113.7",
+		},
+		{
+			name: "Headers from untrusted clients are ignored",
+			headers: map[string]string{
+				"X-Forwarded-For": "1.2.3.4",
+				"X-Real-IP":       "1.2.3.4",
+				"Forwarded":       "for=1.2.3.4",
+			},
+			remoteAddr: "192.168.1.3:1234",
+			want:       "192.168.1.3",
+		},
+		{
+			name: "Only trusted proxies in X-Forwarded-For",
+			headers: map[string]string{
+				"X-Forwarded-For": "10.0.0.3, 10.0.0.2",
+			},
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.3",
+		},
+		{
+			name: "Invalid hop stops the walk",
+			headers: map[string]string{
+				"X-Forwarded-For": "203.0.113.7, garbage, 10.0.0.2",
+			},
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.2",
+		},
+		{
+			name: "Forwarded header",
+			headers: map[string]string{
+				"Forwarded": `for=203.0.113.9;proto=https, for="[2001:db8::1]:4711";by=10.0.0.2`,
+			},
+			header:     HeaderForwarded,
+			remoteAddr: "10.0.0.1:1234",
+			want:       "2001:db8::1",
+		},
+		{
+			name: "Forwarded is ignored by default",
+			headers: map[string]string{
+				"Forwarded":       "for=203.0.113.9",
+				"X-Forwarded-For": "198.51.100.1",
+			},
+			remoteAddr: "10.0.0.1:1234",
+			want:       "198.51.100.1",
+		},
+		{
+			name: "X-Forwarded-For is ignored when reading Forwarded",
+			headers: map[string]string{
+				"Forwarded":       "for=203.0.113.9",
+				"X-Forwarded-For": "198.51.100.1",
+			},
+			header:     HeaderForwarded,
+			remoteAddr: "10.0.0.1:1234",
+			want:       "203.0.113.9",
+		},
+		{
+			name: "Obfuscated Forwarded identifier",
+			headers: map[string]string{
+				"Forwarded": "for=203.0.113.9, for=_hidden",
+			},
+			header:     HeaderForwarded,
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "IPv6 RemoteAddr",
+			headers:    map[string]string{},
+			remoteAddr: "[2001:db8::2]:1234",
+			want:       "2001:db8::2",
+		},
+		{
+			name: "IPv6 trusted proxy",
+			headers: map[string]string{
+				"X-Forwarded-For": "2001:db8::3",
+			},
+			remoteAddr: "[fd00::1]:1234",
+			want:       "2001:db8::3",
+		},
+		{
+			name: "Empty X-Forwarded-For",
+			headers: map[string]string{
+				"X-Forwarded-For": "",
+			},
+			remoteAddr: "192.168.1.3:1234",
+			want:       "192.168.1.3",
+		},
+		{
+			name:       "Invalid RemoteAddr format",
+			headers:    map[string]string{},
+			remoteAddr: "invalid",
+			want:       "invalid",
+		},
+	}
+
+	for _, tt := range tests {
+		s.Run(tt.name, func() {
+			req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			got := getClientIP(req, s.trusted, tt.header)
+			s.Equal(tt.want, got, "getClientIP() returned unexpected value")
+		})
+	}
+}
+
+func (s *GetClientIPTestSuite) TestWithoutTrustedProxies() {
+	req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "192.168.1.1")
+
+	s.Equal("10.0.0.1", getClientIP(req, nil, HeaderXForwardedFor))
+}
+
+func (s *GetClientIPTestSuite) TestParseTrustedProxies() {
+	proxies, err := ParseTrustedProxies(" 10.1.2.3/8 ,192.168.0.1, ::1,")
+	s.Require().NoError(err)
+	s.Equal([]netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.0.1/32"),
+		netip.MustParsePrefix("::1/128"),
+	}, proxies)
+
+	proxies, err = ParseTrustedProxies("")
+	s.Require().NoError(err)
+	s.Empty(proxies)
+
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	s.Error(err)
+
+	_, err = ParseTrustedProxies("proxy.local")
+	s.Error(err)
+}
+
+func (s *GetClientIPTestSuite) TestParseClientIPHeader() {
+	for input, want := range map[string]ClientIPHeader{
+		"":                HeaderXForwardedFor,
+		"x-forwarded-for": HeaderXForwardedFor,
+		" Forwarded ":     HeaderForwarded,
+		"X-REAL-IP":       HeaderXRealIP,
+	} {
+		header, err := ParseClientIPHeader(input)
+		s.Require().NoError(err)
+		s.Equal(want, header, "ParseClientIPHeader(%q)", input)
+	}
+
+	_, err := ParseClientIPHeader("CF-Connecting-IP")
+	s.Error(err)
+}
+
+func TestGetClientIP(t *testing.T) {
+	suite.Run(t, new(GetClientIPTestSuite))
+}
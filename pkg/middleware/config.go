@@ -1,12 +1,18 @@
 package middleware
 
 import (
+	"fmt"
+	"net/netip"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
 )
 
 // LoadConfig loads configuration from environment variables or .env file
@@ -17,9 +23,21 @@ func LoadConfig() (*ratelimiter.Config, error) {
 	config := ratelimiter.NewConfig()
 
 	// Load general rate limit settings
+	// Accepts either a plain count or "<requests>/<window>", e.g. "1000/1m".
+	// Further comma separated limits are enforced as additional quotas.
 	if maxReqs := os.Getenv("RATE_LIMIT_MAX_REQUESTS"); maxReqs != "" {
-		if val, err := strconv.Atoi(maxReqs); err == nil {
-			config.MaxRequestsPerSecond = val
+		if limits, err := ratelimiter.ParseLimits(maxReqs); err == nil {
+			config.MaxRequestsPerSecond = limits[0].MaxRequests
+			if limits[0].Window > 0 {
+				config.Window = limits[0].Window
+			}
+			config.Limits = limits[1:]
+		}
+	}
+
+	if window := os.Getenv("RATE_LIMIT_WINDOW"); window != "" {
+		if duration, err := time.ParseDuration(window); err == nil && duration > 0 {
+			config.Window = duration
 		}
 	}
 
@@ -33,10 +51,239 @@ func LoadConfig() (*ratelimiter.Config, error) {
 		config.TokenHeader = tokenHeader
 	}
 
+	if algorithm := os.Getenv("RATE_LIMIT_ALGORITHM"); algorithm != "" {
+		if val, err := ratelimiter.ParseAlgorithm(algorithm); err == nil {
+			config.Algorithm = val
+		}
+	}
+
+	if burst := os.Getenv("RATE_LIMIT_BURST"); burst != "" {
+		if val, err := strconv.Atoi(burst); err == nil {
+			config.Burst = val
+		}
+	}
+
+	if policy := os.Getenv("RATE_LIMIT_UNKNOWN_TOKENS"); policy != "" {
+		if val, err := ratelimiter.ParseUnknownTokenPolicy(policy); err == nil {
+			config.UnknownTokens = val
+		}
+	}
+
+	// Uses the format of subnet limits, e.g. "5/1s,100/1h:10m"
+	if limit := os.Getenv("RATE_LIMIT_UNKNOWN_TOKEN_LIMIT"); limit != "" {
+		if val, err := parseRuleLimit(limit); err == nil {
+			config.UnknownTokenLimit = val
+		}
+	}
+
+	// Keeps tokens and IPs out of storage keys
+	if secret := os.Getenv("RATE_LIMIT_KEY_SECRET"); secret != "" {
+		config.KeyHasher = ratelimiter.NewHMACKeyHasher([]byte(secret))
+	}
+
+	return config, nil
+}
+
+// LoadTrustedProxies loads the proxies allowed to report the client IP from
+// RATE_LIMIT_TRUSTED_PROXIES, a comma separated list of CIDRs. Invalid entries
+// are reported instead of ignored, since silently trusting fewer proxies
+// would limit the proxies themselves.
+func LoadTrustedProxies() ([]netip.Prefix, error) {
+	return ParseTrustedProxies(os.Getenv("RATE_LIMIT_TRUSTED_PROXIES"))
+}
+
+// LoadClientIPHeader loads the forwarding header trusted proxies report the
+// client IP in from RATE_LIMIT_CLIENT_IP_HEADER: X-Forwarded-For (default),
+// Forwarded or X-Real-IP
+func LoadClientIPHeader() (ClientIPHeader, error) {
+	return ParseClientIPHeader(os.Getenv("RATE_LIMIT_CLIENT_IP_HEADER"))
+}
+
+// LoadSubnetConfig loads how client IPs are grouped into subnets from
+// environment:
+//
+//	RATE_LIMIT_IPV4_PREFIX    prefix length of IPv4 keys (default: 32)
+//	RATE_LIMIT_IPV6_PREFIX    prefix length of IPv6 keys (default: 64)
+//	RATE_LIMIT_IPV4_<BITS>    limit of each IPv4 /<BITS> subnet
+//	RATE_LIMIT_IPV6_<BITS>    limit of each IPv6 /<BITS> subnet
+//
+// Subnet limits use the format of RATE_LIMIT_MAX_REQUESTS followed by the
+// block duration, like token limits: RATE_LIMIT_IPV6_48=100/1s,5000/1h:5m.
+// Levels are ordered from the narrowest to the widest subnet.
+func LoadSubnetConfig() (SubnetConfig, error) {
+	var config SubnetConfig
+
+	var err error
+	if config.IPv4Prefix, err = loadPrefix("RATE_LIMIT_IPV4_PREFIX", 32); err != nil {
+		return SubnetConfig{}, err
+	}
+	if config.IPv6Prefix, err = loadPrefix("RATE_LIMIT_IPV6_PREFIX", 128); err != nil {
+		return SubnetConfig{}, err
+	}
+
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+
+		var level SubnetLevel
+		var suffix string
+		switch {
+		case strings.HasPrefix(name, "RATE_LIMIT_IPV4_"):
+			suffix = strings.TrimPrefix(name, "RATE_LIMIT_IPV4_")
+		case strings.HasPrefix(name, "RATE_LIMIT_IPV6_"):
+			suffix = strings.TrimPrefix(name, "RATE_LIMIT_IPV6_")
+			level.IPv6 = true
+		default:
+			continue
+		}
+
+		bits, err := strconv.Atoi(suffix)
+		if err != nil {
+			// RATE_LIMIT_IPV4_PREFIX and other settings
+			continue
+		}
+		maxBits := 32
+		if level.IPv6 {
+			maxBits = 128
+		}
+		if bits < 1 || bits > maxBits {
+			return SubnetConfig{}, fmt.Errorf("invalid %s: want a prefix length between 1 and %d", name, maxBits)
+		}
+		level.Prefix = bits
+
+		if level.Limit, err = parseRuleLimit(value); err != nil {
+			return SubnetConfig{}, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		config.Levels = append(config.Levels, level)
+	}
+
+	sort.Slice(config.Levels, func(i, j int) bool {
+		a, b := config.Levels[i], config.Levels[j]
+		if a.IPv6 != b.IPv6 {
+			return !a.IPv6
+		}
+		return a.Prefix > b.Prefix
+	})
+
 	return config, nil
 }
 
-// LoadRedisConfig loads Redis configuration from environment
+// loadPrefix loads a prefix length of at most maxBits from an environment
+// variable, returning zero when it is not set
+func loadPrefix(name string, maxBits int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	bits, err := strconv.Atoi(value)
+	if err != nil || bits < 1 || bits > maxBits {
+		return 0, fmt.Errorf("invalid %s %q: want a prefix length between 1 and %d", name, value, maxBits)
+	}
+	return bits, nil
+}
+
+// parseRuleLimit parses "<limits>:<block duration>", where limits follow
+// ratelimiter.ParseLimits
+func parseRuleLimit(s string) (ratelimiter.TokenConfig, error) {
+	limitPart, blockPart, ok := strings.Cut(s, ":")
+	if !ok {
+		return ratelimiter.TokenConfig{}, fmt.Errorf("missing block duration in %q", s)
+	}
+
+	limits, err := ratelimiter.ParseLimits(limitPart)
+	if err != nil {
+		return ratelimiter.TokenConfig{}, err
+	}
+
+	blockDuration, err := time.ParseDuration(blockPart)
+	if err != nil {
+		return ratelimiter.TokenConfig{}, fmt.Errorf("invalid block duration %q: %w", blockPart, err)
+	}
+
+	return ratelimiter.TokenConfig{
+		MaxRequestsPerSecond: limits[0].MaxRequests,
+		Window:               limits[0].Window,
+		BlockDuration:        blockDuration,
+		Limits:               limits[1:],
+	}, nil
+}
+
+// LoadRouteRules loads route rules from RATE_LIMIT_ROUTE_<NAME> environment
+// variables, in the format accepted by ParseRouteRule, e.g.
+//
+//	RATE_LIMIT_ROUTE_CHECKOUT="POST /checkout 5/1m:10m"
+//	RATE_LIMIT_ROUTE_HEALTH="GET /health 1000/1s:1m"
+//
+// Each rule is named after the variable in lower case, such as "checkout".
+// Rules are returned sorted by name.
+func LoadRouteRules() ([]RouteRule, error) {
+	var rules []RouteRule
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		suffix, ok := strings.CutPrefix(name, "RATE_LIMIT_ROUTE_")
+		if !ok || suffix == "" {
+			continue
+		}
+
+		rule, err := ParseRouteRule(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		rule.Name = strings.ToLower(suffix)
+		rules = append(rules, rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+
+	if _, err := newRouteTable(rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// ParseRouteRule parses a route rule written as "<pattern> <limits>:<block
+// duration>", where the pattern is a ServeMux pattern and the limits follow
+// ratelimiter.ParseLimits, e.g. "POST /checkout 5/1m,100/24h:10m"
+func ParseRouteRule(s string) (RouteRule, error) {
+	s = strings.TrimSpace(s)
+	i := strings.LastIndex(s, " ")
+	if i < 0 {
+		return RouteRule{}, fmt.Errorf("missing limit in route rule %q", s)
+	}
+
+	limit, err := parseRuleLimit(s[i+1:])
+	if err != nil {
+		return RouteRule{}, err
+	}
+
+	return RouteRule{Pattern: strings.TrimSpace(s[:i]), Limit: limit}, nil
+}
+
+// LoadFailurePolicy loads what happens to requests the rate limiter fails to
+// check from RATE_LIMIT_FAILURE_POLICY: "closed" (default), "open" or
+// "local"
+func LoadFailurePolicy() (FailurePolicy, error) {
+	return ParseFailurePolicy(os.Getenv("RATE_LIMIT_FAILURE_POLICY"))
+}
+
+// LoadStorageTimeout loads the timeout of each storage call, for use with
+// storage.NewTimeoutStorage, from RATE_LIMIT_STORAGE_TIMEOUT. It is zero,
+// meaning no timeout, when not set.
+func LoadStorageTimeout() (time.Duration, error) {
+	value := os.Getenv("RATE_LIMIT_STORAGE_TIMEOUT")
+	if value == "" {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid RATE_LIMIT_STORAGE_TIMEOUT %q", value)
+	}
+	return timeout, nil
+}
+
+// LoadRedisConfig loads Redis configuration from environment. The key prefix
+// is loaded separately by LoadRedisKeyPrefix.
 func LoadRedisConfig() (addr, password string, db int) {
 	addr = os.Getenv("REDIS_ADDR")
 	if addr == "" {
@@ -52,4 +299,90 @@ func LoadRedisConfig() (addr, password string, db int) {
 	}
 
 	return
-}
\ No newline at end of file
+}
+
+// LoadRedisKeyPrefix loads the prefix of every Redis key from REDIS_KEY_PREFIX,
+// e.g. "rl:checkout:prod:", for use with storage.WithKeyPrefix. It is empty
+// when not set.
+func LoadRedisKeyPrefix() string {
+	return os.Getenv("REDIS_KEY_PREFIX")
+}
+
+// LoadRedisOptions loads Redis configuration from environment, including
+// Sentinel and Cluster deployments. It understands the same variables as
+// LoadRedisConfig plus:
+//
+//	REDIS_USERNAME           ACL username
+//	REDIS_SENTINEL_MASTER    name of the Sentinel-managed primary
+//	REDIS_SENTINEL_ADDRS     comma separated Sentinel addresses
+//	REDIS_SENTINEL_PASSWORD  password of the Sentinel nodes
+//	REDIS_CLUSTER_ADDRS      comma separated Redis Cluster seed addresses
+//
+// Sentinel takes precedence over Cluster, which takes precedence over the
+// single node in REDIS_ADDR.
+func LoadRedisOptions() *redis.UniversalOptions {
+	addr, password, db := LoadRedisConfig()
+
+	opts := &redis.UniversalOptions{
+		Addrs:    []string{addr},
+		Username: os.Getenv("REDIS_USERNAME"),
+		Password: password,
+		DB:       db,
+		// Lets storage.TimeoutStorage cancel calls
+		ContextTimeoutEnabled: true,
+	}
+
+	if master := os.Getenv("REDIS_SENTINEL_MASTER"); master != "" {
+		opts.MasterName = master
+		opts.SentinelPassword = os.Getenv("REDIS_SENTINEL_PASSWORD")
+		if addrs := splitAddrs(os.Getenv("REDIS_SENTINEL_ADDRS")); len(addrs) > 0 {
+			opts.Addrs = addrs
+		}
+		return opts
+	}
+
+	if addrs := splitAddrs(os.Getenv("REDIS_CLUSTER_ADDRS")); len(addrs) > 0 {
+		opts.Addrs = addrs
+		opts.IsClusterMode = true
+		// Redis Cluster only has database 0
+		opts.DB = 0
+	}
+
+	return opts
+}
+
+// splitAddrs splits a comma separated list of addresses, ignoring blanks
+func splitAddrs(s string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// LoadMemoryConfig loads in-memory storage configuration from environment
+func LoadMemoryConfig() storage.MemoryOptions {
+	var opts storage.MemoryOptions
+
+	if interval := os.Getenv("RATE_LIMIT_CLEANUP_INTERVAL"); interval != "" {
+		if duration, err := time.ParseDuration(interval); err == nil {
+			opts.CleanupInterval = duration
+		}
+	}
+
+	if maxKeys := os.Getenv("RATE_LIMIT_MAX_KEYS"); maxKeys != "" {
+		if val, err := strconv.Atoi(maxKeys); err == nil {
+			opts.MaxKeys = val
+		}
+	}
+
+	if shards := os.Getenv("RATE_LIMIT_SHARDS"); shards != "" {
+		if val, err := strconv.Atoi(shards); err == nil {
+			opts.Shards = val
+		}
+	}
+
+	return opts
+}
@@ -0,0 +1,261 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadRedisOptions(t *testing.T) {
+	t.Run("Single node", func(t *testing.T) {
+		t.Setenv("REDIS_ADDR", "redis:6379")
+		t.Setenv("REDIS_DB", "2")
+
+		opts := LoadRedisOptions()
+		if len(opts.Addrs) != 1 || opts.Addrs[0] != "redis:6379" {
+			t.Errorf("Addrs = %v, want [redis:6379]", opts.Addrs)
+		}
+		if opts.DB != 2 {
+			t.Errorf("DB = %v, want 2", opts.DB)
+		}
+		if opts.MasterName != "" || opts.IsClusterMode {
+			t.Error("Expected single node options")
+		}
+	})
+
+	t.Run("Sentinel", func(t *testing.T) {
+		t.Setenv("REDIS_SENTINEL_MASTER", "mymaster")
+		t.Setenv("REDIS_SENTINEL_ADDRS", "sentinel-1:26379, sentinel-2:26379")
+		t.Setenv("REDIS_SENTINEL_PASSWORD", "secret")
+		t.Setenv("REDIS_CLUSTER_ADDRS", "node-1:6379")
+
+		opts := LoadRedisOptions()
+		if opts.MasterName != "mymaster" {
+			t.Errorf("MasterName = %v, want mymaster", opts.MasterName)
+		}
+		if len(opts.Addrs) != 2 || opts.Addrs[1] != "sentinel-2:26379" {
+			t.Errorf("Addrs = %v, want sentinel addresses", opts.Addrs)
+		}
+		if opts.SentinelPassword != "secret" {
+			t.Errorf("SentinelPassword = %v, want secret", opts.SentinelPassword)
+		}
+		if opts.IsClusterMode {
+			t.Error("Sentinel should take precedence over Cluster")
+		}
+	})
+
+	t.Run("Cluster", func(t *testing.T) {
+		t.Setenv("REDIS_DB", "2")
+		t.Setenv("REDIS_CLUSTER_ADDRS", "node-1:6379,node-2:6379,node-3:6379")
+
+		opts := LoadRedisOptions()
+		if !opts.IsClusterMode {
+			t.Error("Expected cluster mode")
+		}
+		if len(opts.Addrs) != 3 {
+			t.Errorf("Addrs = %v, want 3 cluster nodes", opts.Addrs)
+		}
+		if opts.DB != 0 {
+			t.Errorf("DB = %v, want 0 on Redis Cluster", opts.DB)
+		}
+	})
+}
+
+func TestLoadRedisKeyPrefix(t *testing.T) {
+	if prefix := LoadRedisKeyPrefix(); prefix != "" {
+		t.Errorf("LoadRedisKeyPrefix() = %q, want empty", prefix)
+	}
+
+	t.Setenv("REDIS_KEY_PREFIX", "rl:checkout:prod:")
+	if prefix := LoadRedisKeyPrefix(); prefix != "rl:checkout:prod:" {
+		t.Errorf("LoadRedisKeyPrefix() = %q, want rl:checkout:prod:", prefix)
+	}
+}
+
+func TestLoadStorageTimeout(t *testing.T) {
+	timeout, err := LoadStorageTimeout()
+	if err != nil || timeout != 0 {
+		t.Errorf("LoadStorageTimeout() = %v, %v, want 0, nil", timeout, err)
+	}
+
+	t.Setenv("RATE_LIMIT_STORAGE_TIMEOUT", "50ms")
+	timeout, err = LoadStorageTimeout()
+	if err != nil || timeout != 50*time.Millisecond {
+		t.Errorf("LoadStorageTimeout() = %v, %v, want 50ms, nil", timeout, err)
+	}
+
+	t.Setenv("RATE_LIMIT_STORAGE_TIMEOUT", "soon")
+	if _, err := LoadStorageTimeout(); err == nil {
+		t.Error("LoadStorageTimeout() should reject invalid durations")
+	}
+}
+
+func TestLoadTrustedProxies(t *testing.T) {
+	t.Run("Not set", func(t *testing.T) {
+		t.Setenv("RATE_LIMIT_TRUSTED_PROXIES", "")
+
+		proxies, err := LoadTrustedProxies()
+		if err != nil {
+			t.Fatalf("LoadTrustedProxies() error = %v", err)
+		}
+		if len(proxies) != 0 {
+			t.Errorf("proxies = %v, want none", proxies)
+		}
+	})
+
+	t.Run("CIDRs", func(t *testing.T) {
+		t.Setenv("RATE_LIMIT_TRUSTED_PROXIES", "10.0.0.0/8,fd00::/8")
+
+		proxies, err := LoadTrustedProxies()
+		if err != nil {
+			t.Fatalf("LoadTrustedProxies() error = %v", err)
+		}
+		if len(proxies) != 2 || proxies[0].String() != "10.0.0.0/8" || proxies[1].String() != "fd00::/8" {
+			t.Errorf("proxies = %v, want [10.0.0.0/8 fd00::/8]", proxies)
+		}
+	})
+
+	t.Run("Invalid CIDR", func(t *testing.T) {
+		t.Setenv("RATE_LIMIT_TRUSTED_PROXIES", "10.0.0.0/8,not-a-cidr")
+
+		if _, err := LoadTrustedProxies(); err == nil {
+			t.Error("Expected error for invalid CIDR")
+		}
+	})
+}
+
+func TestLoadClientIPHeader(t *testing.T) {
+	t.Setenv("RATE_LIMIT_CLIENT_IP_HEADER", "")
+	header, err := LoadClientIPHeader()
+	if err != nil || header != HeaderXForwardedFor {
+		t.Errorf("LoadClientIPHeader() = %q, %v, want X-Forwarded-For, nil", header, err)
+	}
+
+	t.Setenv("RATE_LIMIT_CLIENT_IP_HEADER", "forwarded")
+	header, err = LoadClientIPHeader()
+	if err != nil || header != HeaderForwarded {
+		t.Errorf("LoadClientIPHeader() = %q, %v, want Forwarded, nil", header, err)
+	}
+
+	t.Setenv("RATE_LIMIT_CLIENT_IP_HEADER", "True-Client-IP")
+	if _, err := LoadClientIPHeader(); err == nil {
+		t.Error("LoadClientIPHeader() should reject unknown headers")
+	}
+}
+
+func TestLoadSubnetConfig(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		config, err := LoadSubnetConfig()
+		if err != nil {
+			t.Fatalf("LoadSubnetConfig() error = %v", err)
+		}
+		if config.IPv4Prefix != 0 || config.IPv6Prefix != 0 || len(config.Levels) != 0 {
+			t.Errorf("config = %+v, want zero value", config)
+		}
+	})
+
+	t.Run("Prefixes and levels", func(t *testing.T) {
+		t.Setenv("RATE_LIMIT_IPV4_PREFIX", "32")
+		t.Setenv("RATE_LIMIT_IPV6_PREFIX", "56")
+		t.Setenv("RATE_LIMIT_IPV6_32", "1000/1s:10m")
+		t.Setenv("RATE_LIMIT_IPV6_48", "100/1s,5000/1h:5m")
+		t.Setenv("RATE_LIMIT_IPV4_24", "50:1m")
+
+		config, err := LoadSubnetConfig()
+		if err != nil {
+			t.Fatalf("LoadSubnetConfig() error = %v", err)
+		}
+		if config.IPv4Prefix != 32 || config.IPv6Prefix != 56 {
+			t.Errorf("prefixes = %d/%d, want 32/56", config.IPv4Prefix, config.IPv6Prefix)
+		}
+
+		if len(config.Levels) != 3 {
+			t.Fatalf("len(Levels) = %d, want 3", len(config.Levels))
+		}
+		want := []struct {
+			ipv6   bool
+			prefix int
+			max    int
+		}{
+			{false, 24, 50},
+			{true, 48, 100},
+			{true, 32, 1000},
+		}
+		for i, w := range want {
+			level := config.Levels[i]
+			if level.IPv6 != w.ipv6 || level.Prefix != w.prefix || level.Limit.MaxRequestsPerSecond != w.max {
+				t.Errorf("Levels[%d] = %+v, want %+v", i, level, w)
+			}
+		}
+		if config.Levels[1].Limit.BlockDuration != 5*time.Minute || len(config.Levels[1].Limit.Limits) != 1 {
+			t.Errorf("Levels[1].Limit = %+v, want a 5m block and one additional limit", config.Levels[1].Limit)
+		}
+	})
+
+	t.Run("Invalid prefix", func(t *testing.T) {
+		t.Setenv("RATE_LIMIT_IPV4_PREFIX", "33")
+
+		if _, err := LoadSubnetConfig(); err == nil {
+			t.Error("Expected error for invalid prefix length")
+		}
+	})
+
+	t.Run("Invalid level", func(t *testing.T) {
+		t.Setenv("RATE_LIMIT_IPV4_40", "10:1m")
+
+		if _, err := LoadSubnetConfig(); err == nil {
+			t.Error("Expected error for invalid prefix length")
+		}
+	})
+
+	t.Run("Missing block duration", func(t *testing.T) {
+		t.Setenv("RATE_LIMIT_IPV6_48", "100/1s")
+
+		if _, err := LoadSubnetConfig(); err == nil {
+			t.Error("Expected error for missing block duration")
+		}
+	})
+}
+
+func TestLoadRouteRules(t *testing.T) {
+	t.Run("Rules", func(t *testing.T) {
+		t.Setenv("RATE_LIMIT_ROUTE_CHECKOUT", "POST /checkout 5/1m,100/24h:10m")
+		t.Setenv("RATE_LIMIT_ROUTE_HEALTH", "GET /health 1000:1m")
+
+		rules, err := LoadRouteRules()
+		if err != nil {
+			t.Fatalf("LoadRouteRules() error = %v", err)
+		}
+		if len(rules) != 2 {
+			t.Fatalf("len(rules) = %d, want 2", len(rules))
+		}
+
+		checkout := rules[0]
+		if checkout.Name != "checkout" || checkout.Pattern != "POST /checkout" {
+			t.Errorf("rules[0] = %+v, want checkout for POST /checkout", checkout)
+		}
+		if checkout.Limit.MaxRequestsPerSecond != 5 || checkout.Limit.Window != time.Minute ||
+			checkout.Limit.BlockDuration != 10*time.Minute || len(checkout.Limit.Limits) != 1 {
+			t.Errorf("rules[0].Limit = %+v", checkout.Limit)
+		}
+
+		if rules[1].Name != "health" || rules[1].Pattern != "GET /health" || rules[1].Limit.MaxRequestsPerSecond != 1000 {
+			t.Errorf("rules[1] = %+v, want health for GET /health", rules[1])
+		}
+	})
+
+	t.Run("Missing limit", func(t *testing.T) {
+		t.Setenv("RATE_LIMIT_ROUTE_CHECKOUT", "/checkout")
+
+		if _, err := LoadRouteRules(); err == nil {
+			t.Error("Expected error for missing limit")
+		}
+	})
+
+	t.Run("Invalid pattern", func(t *testing.T) {
+		t.Setenv("RATE_LIMIT_ROUTE_CHECKOUT", "GET checkout 5:1m")
+
+		if _, err := LoadRouteRules(); err == nil {
+			t.Error("Expected error for invalid pattern")
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// CostFunc returns the number of units a request consumes from its limits.
+// Values below one count as one.
+type CostFunc func(r *http.Request) int
+
+// WithCost makes requests consume the units returned by cost instead of one,
+// so that expensive endpoints such as searches or exports use up the limits
+// faster than cheap reads
+func WithCost(cost CostFunc) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.cost = cost
+	}
+}
+
+// CostByRoute returns the cost of the route pattern matching a request, such
+// as {"GET /search": 5, "POST /export": 20}, or one when none matches. When
+// several patterns match, the most specific one wins, as in ServeMux.
+//
+// Like ServeMux.Handle, it panics on invalid or conflicting patterns.
+func CostByRoute(costs map[string]int) CostFunc {
+	mux := http.NewServeMux()
+	noop := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	for pattern := range costs {
+		mux.Handle(pattern, noop)
+	}
+
+	return func(r *http.Request) int {
+		_, pattern := mux.Handler(r)
+		if cost, ok := costs[pattern]; ok {
+			return cost
+		}
+		return 1
+	}
+}
+
+// CostFromHeader returns the cost a request declares in a header, such as the
+// number of items of a batch, or one when the header is missing or invalid.
+// Clients control the header, so only use it for costs they can't benefit
+// from understating. Overstated costs only hurt the client: costs above the
+// limit are denied, and may block the client, without overflowing counters.
+func CostFromHeader(name string) CostFunc {
+	return func(r *http.Request) int {
+		cost, err := strconv.Atoi(r.Header.Get(name))
+		if err != nil {
+			return 1
+		}
+		return cost
+	}
+}
+
+// requestCost returns the number of units a request consumes, or zero for
+// the limiter's default of one when no cost function is set
+func (m *RateLimiterMiddleware) requestCost(r *http.Request) int {
+	if m.cost == nil {
+		return 0
+	}
+	return max(1, m.cost(r))
+}
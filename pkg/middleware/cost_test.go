@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/test"
+)
+
+func TestCostByRoute(t *testing.T) {
+	cost := CostByRoute(map[string]int{
+		"GET /search":  5,
+		"POST /export": 20,
+		"/reports/":    3,
+	})
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/search", 5},
+		{"POST", "/export", 20},
+		{"GET", "/export", 1},
+		{"GET", "/reports/monthly", 3},
+		{"GET", "/users/1", 1},
+	}
+	for _, tt := range tests {
+		if got := cost(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+			t.Errorf("cost of %s %s = %d, want %d", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCostFromHeader(t *testing.T) {
+	cost := CostFromHeader("X-Batch-Size")
+
+	req := httptest.NewRequest("POST", "/batch", nil)
+	if got := cost(req); got != 1 {
+		t.Errorf("cost without header = %d, want 1", got)
+	}
+
+	req.Header.Set("X-Batch-Size", "7")
+	if got := cost(req); got != 7 {
+		t.Errorf("cost = %d, want 7", got)
+	}
+
+	req.Header.Set("X-Batch-Size", "many")
+	if got := cost(req); got != 1 {
+		t.Errorf("cost with invalid header = %d, want 1", got)
+	}
+}
+
+func TestWithCost(t *testing.T) {
+	limiter := &mockLimiter{allowed: true}
+	middleware := New(limiter, &ratelimiter.Config{TokenHeader: "API_KEY"},
+		WithCost(func(r *http.Request) int { return -3 }))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(limiter.requests) != 1 || limiter.requests[0].Cost != 1 {
+		t.Errorf("requests = %+v, want one request costing 1", limiter.requests)
+	}
+}
+
+func TestCostConsumesLimit(t *testing.T) {
+	store := test.NewMemoryStorage()
+	defer store.Close()
+
+	config := ratelimiter.NewConfig()
+	config.MaxRequestsPerSecond = 10
+	config.BlockDuration = time.Minute
+
+	limiter := ratelimiter.New(store, config)
+	middleware := New(limiter, config, WithCost(CostByRoute(map[string]int{"GET /export": 4})))
+	handler := middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "192.168.1.1:1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := serve("/export"); w.Code != http.StatusOK {
+			t.Fatalf("Export %d should pass, got %d", i+1, w.Code)
+		}
+	}
+
+	w := serve("/items")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Cheap request should pass, got %d", w.Code)
+	}
+	if remaining := w.Header().Get("X-RateLimit-Remaining"); remaining != "1" {
+		t.Errorf("X-RateLimit-Remaining = %s, want 1", remaining)
+	}
+
+	// One unit is left, which is not enough for another export
+	if w := serve("/export"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Third export should be blocked, got %d", w.Code)
+	}
+}
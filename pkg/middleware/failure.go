@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
+)
+
+// FailurePolicy decides what happens to requests the rate limiter fails to
+// check, e.g. while Redis is unavailable
+type FailurePolicy string
+
+const (
+	// FailClosed responds with 500 Internal Server Error (default)
+	FailClosed FailurePolicy = "closed"
+
+	// FailOpen lets requests through without rate limit headers
+	FailOpen FailurePolicy = "open"
+
+	// FailLocal checks requests against a fallback limiter, by default one
+	// with the same config backed by in-memory storage. Each instance then
+	// counts its own requests only, so clients get a share of the limit per
+	// instance until the storage is back.
+	FailLocal FailurePolicy = "local"
+)
+
+// ParseFailurePolicy converts a string such as "open" into a FailurePolicy
+func ParseFailurePolicy(s string) (FailurePolicy, error) {
+	switch p := FailurePolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case FailClosed, FailOpen, FailLocal:
+		return p, nil
+	case "":
+		return FailClosed, nil
+	default:
+		return "", fmt.Errorf("unknown failure policy %q", s)
+	}
+}
+
+// WithFailurePolicy sets what happens to requests the rate limiter fails to
+// check. Combine it with storage.NewTimeoutStorage so that a storage that
+// hangs fails quickly instead of holding every request.
+func WithFailurePolicy(policy FailurePolicy) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.failurePolicy = policy
+	}
+}
+
+// WithFallbackLimiter sets the limiter requests are checked against under
+// the FailLocal policy, instead of an in-memory one with the same config
+func WithFallbackLimiter(limiter ratelimiter.RateLimiterInterface) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.fallback = limiter
+	}
+}
+
+// WithErrorHook calls hook with every error of the rate limiter, before the
+// failure policy applies, so that storage outages show up in logs and
+// metrics even when requests keep being served
+func WithErrorHook(hook func(r *http.Request, err error)) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.errorHooks = append(m.errorHooks, hook)
+	}
+}
+
+// newFallbackLimiter returns a limiter with the middleware's config backed by
+// in-memory storage, which Close closes
+func (m *RateLimiterMiddleware) newFallbackLimiter() ratelimiter.RateLimiterInterface {
+	m.fallbackStorage = storage.NewMemoryStorage(storage.MemoryOptions{})
+	return ratelimiter.New(m.fallbackStorage, m.config)
+}
+
+// Close releases the resources the middleware created itself, such as the
+// storage of the default FailLocal fallback limiter. The limiters passed to
+// the middleware are left to their owners.
+func (m *RateLimiterMiddleware) Close() error {
+	if m.fallbackStorage == nil {
+		return nil
+	}
+	return m.fallbackStorage.Close()
+}
+
+// reportError passes a rate limiter error to the error hooks
+func (m *RateLimiterMiddleware) reportError(r *http.Request, err error) {
+	for _, hook := range m.errorHooks {
+		hook(r, err)
+	}
+}
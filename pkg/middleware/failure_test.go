@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
+)
+
+func TestParseFailurePolicy(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    FailurePolicy
+		wantErr bool
+	}{
+		{input: "closed", want: FailClosed},
+		{input: "OPEN", want: FailOpen},
+		{input: " local ", want: FailLocal},
+		{input: "", want: FailClosed},
+		{input: "retry", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseFailurePolicy(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFailurePolicy(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseFailurePolicy(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailurePolicies(t *testing.T) {
+	storageErr := errors.New("redis: connection refused")
+
+	serve := func(opts ...Option) (*httptest.ResponseRecorder, []error) {
+		var reported []error
+		opts = append(opts, WithErrorHook(func(r *http.Request, err error) {
+			reported = append(reported, err)
+		}))
+
+		config := ratelimiter.NewConfig()
+		config.MaxRequestsPerSecond = 1
+		config.BlockDuration = time.Minute
+		middleware := New(&mockLimiter{err: storageErr}, config, opts...)
+		defer middleware.Close()
+		handler := middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.168.1.1:1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w, reported
+	}
+
+	t.Run("Closed", func(t *testing.T) {
+		w, reported := serve()
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected status 500, got %d", w.Code)
+		}
+		if len(reported) != 1 || !errors.Is(reported[0], storageErr) {
+			t.Errorf("reported = %v, want the storage error", reported)
+		}
+	})
+
+	t.Run("Open", func(t *testing.T) {
+		w, reported := serve(WithFailurePolicy(FailOpen))
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", w.Code)
+		}
+		if w.Header().Get("X-RateLimit-Limit") != "" {
+			t.Error("Expected no rate limit headers")
+		}
+		if len(reported) != 1 {
+			t.Errorf("reported = %v, want the storage error", reported)
+		}
+	})
+
+	t.Run("Local", func(t *testing.T) {
+		w, reported := serve(WithFailurePolicy(FailLocal))
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", w.Code)
+		}
+		if limit := w.Header().Get("X-RateLimit-Limit"); limit != "1" {
+			t.Errorf("X-RateLimit-Limit = %s, want 1 from the local limiter", limit)
+		}
+		if len(reported) != 1 {
+			t.Errorf("reported = %v, want the storage error", reported)
+		}
+	})
+
+	t.Run("Local with a failing fallback", func(t *testing.T) {
+		w, _ := serve(WithFailurePolicy(FailLocal), WithFallbackLimiter(&mockLimiter{err: storageErr}))
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected status 500, got %d", w.Code)
+		}
+	})
+}
+
+func TestCloseReleasesFallbackStorage(t *testing.T) {
+	config := ratelimiter.NewConfig()
+	middleware := New(&mockLimiter{err: errors.New("redis: connection refused")}, config, WithFailurePolicy(FailLocal))
+	handler := middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.168.1.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	store, ok := middleware.fallbackStorage.(*storage.MemoryStorage)
+	if !ok || store.Len() != 1 {
+		t.Fatal("The request should be counted by the fallback storage")
+	}
+	if err := middleware.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if store.Len() != 0 {
+		t.Error("Close() should close the fallback storage")
+	}
+
+	// Middlewares without a fallback storage have nothing to close
+	if err := New(&mockLimiter{}, config).Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoggerFailurePolicy(t *testing.T) {
+	tests := []struct {
+		policy FailurePolicy
+		level  string
+	}{
+		{policy: FailClosed, level: "ERROR"},
+		{policy: FailOpen, level: "WARN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+			limiter := &mockLimiter{err: errors.New("redis: connection refused")}
+			middleware := New(limiter, ratelimiter.NewConfig(), WithFailurePolicy(tt.policy), WithLogger(logger))
+			handler := middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders", nil))
+
+			var record map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+				t.Fatal(err)
+			}
+			if record["level"] != tt.level || record["policy"] != string(tt.policy) || record["path"] != "/orders" {
+				t.Errorf("Record = %v, want level %s with the policy and path", record, tt.level)
+			}
+			if record["error"] != "redis: connection refused" {
+				t.Errorf("Record error = %v, want the limiter error", record["error"])
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"testing"
 	"time"
 
@@ -22,7 +23,7 @@ func TestRateLimiterMiddleware_Integration(t *testing.T) {
 	config.SetTokenLimit("test-token", 5, time.Second*3)
 
 	limiter := ratelimiter.New(store, config)
-	middleware := New(limiter, config)
+	middleware := New(limiter, config, WithTrustedProxies(netip.MustParsePrefix("10.0.0.0/8")))
 
 	// Simple test handler
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -162,7 +163,7 @@ func TestRateLimiterMiddleware_Integration(t *testing.T) {
 		req.RemoteAddr = "10.0.0.1:12345"
 		req.Header.Set("X-Forwarded-For", "203.0.113.1, 198.51.100.1")
 
-		// Should use first IP from X-Forwarded-For
+		// Should use the rightmost untrusted IP from X-Forwarded-For
 		for i := 0; i < 2; i++ {
 			w := httptest.NewRecorder()
 			wrappedHandler.ServeHTTP(w, req)
@@ -237,6 +238,10 @@ func (m *mockErrorLimiter) GetRemainingRequests(ctx context.Context, key string,
 	return 0, &mockError{"test error"}
 }
 
+func (m *mockErrorLimiter) Allow(ctx context.Context, req ratelimiter.Request) (ratelimiter.Decision, error) {
+	return ratelimiter.Decision{}, &mockError{"test error"}
+}
+
 type mockError struct {
 	msg string
 }
@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// KeyExtractor identifies the client a request is counted against, such as
+// a user ID, a tenant or an endpoint
+type KeyExtractor interface {
+	// Extract returns the key of a request, or false when the request does
+	// not carry one, e.g. a missing header
+	Extract(r *http.Request) (string, bool)
+}
+
+// KeyExtractorFunc adapts a function to the KeyExtractor interface
+type KeyExtractorFunc func(r *http.Request) (string, bool)
+
+// Extract calls f(r)
+func (f KeyExtractorFunc) Extract(r *http.Request) (string, bool) {
+	return f(r)
+}
+
+// WithKeyExtractors makes the middleware identify requests without a token
+// by the first extractor that finds a key, instead of by client IP. Requests
+// none of them identify are still limited by client IP. Keys are limited
+// with the default limits.
+//
+// The built-in extractors prefix their keys with what they read, such as
+// "header:X-Tenant-ID:acme", so that keys from different extractors never
+// share a counter.
+func WithKeyExtractors(extractors ...KeyExtractor) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.extractors = append(m.extractors, extractors...)
+	}
+}
+
+// HeaderKey identifies requests by the value of a header
+func HeaderKey(name string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		return prefixed("header:"+name, r.Header.Get(name))
+	})
+}
+
+// CookieKey identifies requests by the value of a cookie
+func CookieKey(name string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return "", false
+		}
+		return prefixed("cookie:"+name, cookie.Value)
+	})
+}
+
+// QueryKey identifies requests by the value of a query parameter
+func QueryKey(param string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		return prefixed("query:"+param, r.URL.Query().Get(param))
+	})
+}
+
+// JWTClaimKey identifies requests by a claim of the JWT in the
+// "Authorization: Bearer" header, such as "sub" or "tenant_id".
+//
+// The token's signature is not verified, so anyone can send a token with any
+// claim. Only use it behind a gateway or middleware that rejects invalid
+// tokens.
+func JWTClaimKey(claim string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			return "", false
+		}
+
+		value, ok := jwtClaim(strings.TrimSpace(token), claim)
+		if !ok {
+			return "", false
+		}
+		return prefixed("jwt:"+claim, value)
+	})
+}
+
+// PathKey identifies requests by route. Behind a ServeMux the route is the
+// matched pattern, such as "GET /users/{id}", so that every user shares one
+// key; otherwise it is the URL path.
+func PathKey() KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		if r.Pattern != "" {
+			return prefixed("path", r.Pattern)
+		}
+		return prefixed("path", r.URL.Path)
+	})
+}
+
+// MethodKey identifies requests by HTTP method
+func MethodKey() KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		return prefixed("method", r.Method)
+	})
+}
+
+// ClientIPKey identifies requests by client IP, as resolved by the
+// middleware. It is meant to be combined with other extractors, e.g.
+// CompositeKey(ClientIPKey(), PathKey()) limits each client per route.
+func ClientIPKey() KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		ip, _ := r.Context().Value(clientIPContextKey{}).(string)
+		return prefixed("ip", ip)
+	})
+}
+
+// CompositeKey identifies requests by the keys of every extractor together,
+// and only when all of them find one
+func CompositeKey(extractors ...KeyExtractor) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		if len(extractors) == 0 {
+			return "", false
+		}
+
+		keys := make([]string, len(extractors))
+		for i, extractor := range extractors {
+			key, ok := extractor.Extract(r)
+			if !ok {
+				return "", false
+			}
+			keys[i] = key
+		}
+		return strings.Join(keys, "|"), true
+	})
+}
+
+// clientIPContextKey holds the client IP key in the request context while
+// extractors run
+type clientIPContextKey struct{}
+
+// extractKey returns the key of the first extractor that identifies the
+// request. ip is the request's client IP key, made available to ClientIPKey.
+func (m *RateLimiterMiddleware) extractKey(r *http.Request, ip string) (string, bool) {
+	if len(m.extractors) == 0 {
+		return "", false
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), clientIPContextKey{}, ip))
+	for _, extractor := range m.extractors {
+		if key, ok := extractor.Extract(r); ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// prefixed returns "<kind>:<value>", or false when value is empty
+func prefixed(kind, value string) (string, bool) {
+	if value == "" {
+		return "", false
+	}
+	return kind + ":" + value, true
+}
+
+// jwtClaim returns a claim of a JWT's payload, without verifying the token
+func jwtClaim(token, claim string) (string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", false
+	}
+
+	var claims map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return "", false
+	}
+
+	switch value := claims[claim].(type) {
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	default:
+		return "", false
+	}
+}
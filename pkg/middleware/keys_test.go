@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+	"github.com/stretchr/testify/suite"
+)
+
+type KeyExtractorTestSuite struct {
+	suite.Suite
+}
+
+// jwt builds an unsigned token with the given JSON payload
+func jwt(payload string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(payload)) + ".signature"
+}
+
+func (s *KeyExtractorTestSuite) TestExtractors() {
+	tests := []struct {
+		name      string
+		extractor KeyExtractor
+		prepare   func(r *http.Request)
+		want      string
+		wantOK    bool
+	}{
+		{
+			name:      "Header",
+			extractor: HeaderKey("X-Tenant-ID"),
+			prepare:   func(r *http.Request) { r.Header.Set("X-Tenant-ID", "acme") },
+			want:      "header:X-Tenant-ID:acme",
+			wantOK:    true,
+		},
+		{
+			name:      "Missing header",
+			extractor: HeaderKey("X-Tenant-ID"),
+		},
+		{
+			name:      "Cookie",
+			extractor: CookieKey("session"),
+			prepare:   func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "session", Value: "abc"}) },
+			want:      "cookie:session:abc",
+			wantOK:    true,
+		},
+		{
+			name:      "Missing cookie",
+			extractor: CookieKey("session"),
+		},
+		{
+			name:      "Query parameter",
+			extractor: QueryKey("api_key"),
+			prepare:   func(r *http.Request) { r.URL.RawQuery = "api_key=xyz" },
+			want:      "query:api_key:xyz",
+			wantOK:    true,
+		},
+		{
+			name:      "JWT string claim",
+			extractor: JWTClaimKey("sub"),
+			prepare:   func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+jwt(`{"sub":"user-42"}`)) },
+			want:      "jwt:sub:user-42",
+			wantOK:    true,
+		},
+		{
+			name:      "JWT numeric claim",
+			extractor: JWTClaimKey("tenant"),
+			prepare:   func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+jwt(`{"tenant":12345678901}`)) },
+			want:      "jwt:tenant:12345678901",
+			wantOK:    true,
+		},
+		{
+			name:      "JWT without the claim",
+			extractor: JWTClaimKey("tenant"),
+			prepare:   func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+jwt(`{"sub":"user-42"}`)) },
+		},
+		{
+			name:      "Malformed JWT",
+			extractor: JWTClaimKey("sub"),
+			prepare:   func(r *http.Request) { r.Header.Set("Authorization", "Bearer not-a-jwt") },
+		},
+		{
+			name:      "Path",
+			extractor: PathKey(),
+			want:      "path:/foo",
+			wantOK:    true,
+		},
+		{
+			name:      "Route pattern",
+			extractor: PathKey(),
+			prepare:   func(r *http.Request) { r.Pattern = "GET /users/{id}" },
+			want:      "path:GET /users/{id}",
+			wantOK:    true,
+		},
+		{
+			name:      "Method",
+			extractor: MethodKey(),
+			want:      "method:GET",
+			wantOK:    true,
+		},
+		{
+			name:      "Composite",
+			extractor: CompositeKey(MethodKey(), PathKey()),
+			want:      "method:GET|path:/foo",
+			wantOK:    true,
+		},
+		{
+			name:      "Composite with a missing part",
+			extractor: CompositeKey(MethodKey(), HeaderKey("X-Tenant-ID")),
+		},
+		{
+			name:      "Client IP outside the middleware",
+			extractor: ClientIPKey(),
+		},
+	}
+
+	for _, tt := range tests {
+		s.Run(tt.name, func() {
+			req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+			if tt.prepare != nil {
+				tt.prepare(req)
+			}
+
+			got, ok := tt.extractor.Extract(req)
+			s.Equal(tt.wantOK, ok)
+			s.Equal(tt.want, got)
+		})
+	}
+}
+
+func (s *KeyExtractorTestSuite) TestMiddlewareUsesExtractors() {
+	limiter := &mockLimiter{allowed: true}
+	config := &ratelimiter.Config{
+		MaxRequestsPerSecond: 10,
+		BlockDuration:        time.Minute,
+		TokenHeader:          "API_KEY",
+	}
+	middleware := New(limiter, config, WithKeyExtractors(
+		HeaderKey("X-User-ID"),
+		CompositeKey(ClientIPKey(), PathKey()),
+	))
+	handler := middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(headers map[string]string) {
+		req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+		req.RemoteAddr = "192.168.1.1:1234"
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve(map[string]string{"X-User-ID": "42"})
+	serve(nil)
+	serve(map[string]string{"X-User-ID": "42", "API_KEY": "test-token"})
+
+	s.Equal([]ratelimiter.Request{
+		{Key: "header:X-User-ID:42"},
+		{Key: "ip:192.168.1.1|path:/foo"},
+		// Tokens still take precedence
+		{Key: "test-token", IsToken: true},
+	}, limiter.requests)
+}
+
+func (s *KeyExtractorTestSuite) TestMiddlewareFallsBackToClientIP() {
+	limiter := &mockLimiter{allowed: true}
+	config := &ratelimiter.Config{TokenHeader: "API_KEY"}
+	middleware := New(limiter, config, WithKeyExtractors(HeaderKey("X-User-ID")))
+
+	req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	req.RemoteAddr = "192.168.1.1:1234"
+	middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+
+	s.Equal([]ratelimiter.Request{{Key: "192.168.1.1"}}, limiter.requests)
+}
+
+func TestKeyExtractors(t *testing.T) {
+	suite.Run(t, new(KeyExtractorTestSuite))
+}
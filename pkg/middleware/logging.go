@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// WithLogger makes the middleware log with logger:
+//
+//   - requests the rate limiter failed to check, at Error when they are
+//     rejected and at Warn when the failure policy serves them anyway
+//   - requests rejected for an unknown token, at Info
+//
+// Tokens are never logged. Use ratelimiter.WithLogger to log blocks and
+// denials.
+func WithLogger(logger *slog.Logger) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.logger = logger
+	}
+}
+
+// logError logs a rate limiter error and the failure policy applied to the
+// request
+func (m *RateLimiterMiddleware) logError(r *http.Request, err error) {
+	if m.logger == nil {
+		return
+	}
+
+	policy := m.failurePolicy
+	if policy == "" {
+		policy = FailClosed
+	}
+	level, msg := slog.LevelWarn, "rate limiter failed, serving request under failure policy"
+	if policy == FailClosed {
+		level, msg = slog.LevelError, "rate limiter failed, rejecting request"
+	}
+
+	m.logger.LogAttrs(r.Context(), level, msg,
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("policy", string(policy)),
+		slog.Any("error", err),
+	)
+}
+
+// logUnknownToken logs a request rejected for an unknown token
+func (m *RateLimiterMiddleware) logUnknownToken(r *http.Request) {
+	if m.logger == nil {
+		return
+	}
+
+	m.logger.LogAttrs(r.Context(), slog.LevelInfo, "request with unknown API token rejected",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("ip", m.clientIP(r)),
+	)
+}
@@ -2,50 +2,168 @@ package middleware
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
-	"strings"
+	"net/netip"
+	"strconv"
+	"time"
 
 	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
 )
 
 type RateLimiterMiddleware struct {
 	limiter ratelimiter.RateLimiterInterface
 	config  *ratelimiter.Config
+
+	// draftHeaders enables the IETF draft RateLimit and RateLimit-Policy headers
+	draftHeaders bool
+
+	// trustedProxies are allowed to report the client IP in forwarding headers
+	trustedProxies []netip.Prefix
+
+	// clientIPHeader is the forwarding header trusted proxies set
+	clientIPHeader ClientIPHeader
+
+	// subnets groups client IPs into subnets before they are limited
+	subnets SubnetConfig
+
+	// extractors identify requests without a token before falling back to
+	// the client IP
+	extractors []KeyExtractor
+
+	// routes matches requests to route rules
+	routes *routeTable
+
+	// cost returns the number of units a request consumes
+	cost CostFunc
+
+	// tokenValidator decides which tokens are known
+	tokenValidator TokenValidator
+
+	// failurePolicy decides what happens to requests the limiter fails to
+	// check
+	failurePolicy FailurePolicy
+
+	// fallback checks requests under the FailLocal policy
+	fallback ratelimiter.RateLimiterInterface
+
+	// fallbackStorage is the storage of the default fallback limiter, closed
+	// by Close
+	fallbackStorage storage.Storage
+
+	// errorHooks are called with every error of the limiter
+	errorHooks []func(r *http.Request, err error)
+
+	// logger logs limiter errors and unknown tokens, when set
+	logger *slog.Logger
+}
+
+// Option configures optional RateLimiterMiddleware behavior
+type Option func(*RateLimiterMiddleware)
+
+// WithDraftHeaders makes the middleware also emit the RateLimit and
+// RateLimit-Policy headers from the IETF httpapi rate limit headers draft
+func WithDraftHeaders() Option {
+	return func(m *RateLimiterMiddleware) {
+		m.draftHeaders = true
+	}
 }
 
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
-func New(limiter ratelimiter.RateLimiterInterface, config *ratelimiter.Config) *RateLimiterMiddleware {
-	return &RateLimiterMiddleware{
+func New(limiter ratelimiter.RateLimiterInterface, config *ratelimiter.Config, opts ...Option) *RateLimiterMiddleware {
+	m := &RateLimiterMiddleware{
 		limiter: limiter,
 		config:  config,
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.failurePolicy == FailLocal && m.fallback == nil {
+		m.fallback = m.newFallbackLimiter()
+	}
+	m.checkRules(m.limiter)
+	if m.fallback != nil {
+		m.checkRules(m.fallback)
+	}
+	return m
+}
+
+// checkRules panics when limiter doesn't know a rule the middleware
+// registered in its config, which happens when the limiter was built from
+// another Config and would otherwise fail every request selecting the rule.
+// Limiters without a HasRule method are not checked.
+func (m *RateLimiterMiddleware) checkRules(limiter ratelimiter.RateLimiterInterface) {
+	rules, ok := limiter.(interface{ HasRule(string) bool })
+	if !ok {
+		return
+	}
+	for _, name := range m.rules() {
+		if !rules.HasRule(name) {
+			panic(fmt.Sprintf("rate limiter doesn't know rule %q; build it from the config passed to middleware.New", name))
+		}
+	}
+}
+
+// rules returns the names of the rules the middleware registered in its
+// config
+func (m *RateLimiterMiddleware) rules() []string {
+	var names []string
+	for _, level := range m.subnets.Levels {
+		names = append(names, level.rule())
+	}
+	for _, rule := range m.routeRules() {
+		names = append(names, rule.rule())
+	}
+	return names
 }
 
 func (m *RateLimiterMiddleware) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// First check for token-based rate limiting
-		token := r.Header.Get(m.config.TokenHeader)
-		var allowed bool
-		var err error
-
-		if token != "" {
-			// Token-based rate limiting takes precedence
-			allowed, err = m.limiter.IsAllowed(r.Context(), token, true)
+		// The token is validated once, whichever limiter checks the request
+		token, known, err := m.resolveToken(r)
+		var decision ratelimiter.Decision
+		if err != nil {
+			// A token that can't be validated is limited by the fallback
+			// like a request without a token
+			token = ""
 		} else {
-			// Fall back to IP-based rate limiting
-			ip := getClientIP(r)
-			allowed, err = m.limiter.IsAllowed(r.Context(), ip, false)
+			decision, err = m.allow(r, m.limiter, token, known)
+		}
+
+		if err != nil && !errors.Is(err, errUnknownToken) {
+			m.reportError(r, err)
+			m.logError(r, err)
+			switch m.failurePolicy {
+			case FailOpen:
+				next.ServeHTTP(w, r)
+				return
+			case FailLocal:
+				decision, err = m.allow(r, m.fallback, token, known)
+			}
 		}
 
+		if errors.Is(err, errUnknownToken) {
+			m.logUnknownToken(r)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid API token"})
+			return
+		}
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		if !allowed {
+		m.setHeaders(w.Header(), decision, time.Now())
+
+		if !decision.Allowed {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusTooManyRequests)
 			json.NewEncoder(w).Encode(ErrorResponse{
@@ -58,21 +176,63 @@ func (m *RateLimiterMiddleware) Handler(next http.Handler) http.Handler {
 	})
 }
 
-// getClientIP extracts the client IP address from the request
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	forwardedFor := r.Header.Get("X-Forwarded-For")
-	if forwardedFor != "" {
-		// Take the first IP if multiple are present
-		return strings.Split(forwardedFor, ",")[0]
+// allow checks a request against the limits of its token, key or client IP
+// using limiter. The token comes from resolveToken and is empty for requests
+// limited without one.
+func (m *RateLimiterMiddleware) allow(r *http.Request, limiter ratelimiter.RateLimiterInterface, token string, known bool) (ratelimiter.Decision, error) {
+	// Routes with a rule of their own are counted separately
+	rule, _ := m.routes.match(r)
+	cost := m.requestCost(r)
+
+	// First check for token-based rate limiting
+	if token != "" {
+		// Token-based rate limiting takes precedence
+		decision, limited, err := m.allowToken(r, limiter, token, known, rule, cost)
+		if limited {
+			return decision, err
+		}
+	}
+
+	ip := m.clientIP(r)
+	if key, ok := m.extractKey(r, m.ipKey(ip)); ok {
+		return limiter.Allow(r.Context(), ratelimiter.Request{Key: key, Rule: rule, Cost: cost})
+	}
+
+	// Fall back to IP-based rate limiting
+	return m.allowIP(r.Context(), limiter, ip, rule, cost)
+}
+
+// setHeaders describes the rate limit state of a decision in response headers:
+//
+//	X-RateLimit-Limit      requests allowed per window
+//	X-RateLimit-Remaining  requests left in the current window
+//	X-RateLimit-Reset      Unix time in seconds when the window resets
+//	Retry-After            seconds to wait before retrying, on denied requests
+func (m *RateLimiterMiddleware) setHeaders(h http.Header, decision ratelimiter.Decision, now time.Time) {
+	reset := secondsUntil(decision.ResetAt, now)
+
+	h.Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit.MaxRequests))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(now.Unix()+int64(reset), 10))
+
+	if !decision.Allowed {
+		retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+		h.Set("Retry-After", strconv.Itoa(max(1, retryAfter)))
 	}
 
-	// Check X-Real-IP header
-	realIP := r.Header.Get("X-Real-IP")
-	if realIP != "" {
-		return realIP
+	if m.draftHeaders {
+		policy := strconv.Quote(decision.Limit.String())
+		window := secondsUntil(now.Add(decision.Limit.Window), now)
+		h.Set("RateLimit-Policy", fmt.Sprintf("%s;q=%d;w=%d", policy, decision.Limit.MaxRequests, window))
+		h.Set("RateLimit", fmt.Sprintf("%s;r=%d;t=%d", policy, decision.Remaining, reset))
 	}
+}
 
-	// Fall back to RemoteAddr
-	return strings.Split(r.RemoteAddr, ":")[0]
-}
\ No newline at end of file
+// secondsUntil returns the whole number of seconds from now until t, rounded
+// up, or zero when t is in the past
+func secondsUntil(t, now time.Time) int {
+	if !t.After(now) {
+		return 0
+	}
+	return int(math.Ceil(t.Sub(now).Seconds()))
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 	"time"
 
@@ -15,8 +16,10 @@ import (
 
 // mockLimiter implements a simple mock of the rate limiter for testing
 type mockLimiter struct {
-	allowed bool
-	err     error
+	allowed  bool
+	err      error
+	decision ratelimiter.Decision
+	requests []ratelimiter.Request
 }
 
 func (m *mockLimiter) IsAllowed(ctx context.Context, key string, isToken bool) (bool, error) {
@@ -27,6 +30,13 @@ func (m *mockLimiter) GetRemainingRequests(ctx context.Context, key string, isTo
 	return 0, nil
 }
 
+func (m *mockLimiter) Allow(ctx context.Context, req ratelimiter.Request) (ratelimiter.Decision, error) {
+	m.requests = append(m.requests, req)
+	decision := m.decision
+	decision.Allowed = m.allowed
+	return decision, m.err
+}
+
 type MiddlewareTestSuite struct {
 	suite.Suite
 	config      *ratelimiter.Config
@@ -139,78 +149,104 @@ func (s *MiddlewareTestSuite) TestRateLimiterMiddleware() {
 	}
 }
 
-type GetClientIPTestSuite struct {
-	suite.Suite
+func (s *MiddlewareTestSuite) TestRateLimitHeaders() {
+	limiter := &mockLimiter{
+		allowed: true,
+		decision: ratelimiter.Decision{
+			Limit:     ratelimiter.Limit{MaxRequests: 10, Window: time.Minute},
+			Remaining: 7,
+			ResetAt:   time.Now().Add(30 * time.Second),
+		},
+	}
+	middleware := New(limiter, s.config)
+
+	req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	w := httptest.NewRecorder()
+	middleware.Handler(s.nextHandler).ServeHTTP(w, req)
+
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("10", w.Header().Get("X-RateLimit-Limit"))
+	s.Equal("7", w.Header().Get("X-RateLimit-Remaining"))
+	reset, err := strconv.ParseInt(w.Header().Get("X-RateLimit-Reset"), 10, 64)
+	s.Require().NoError(err)
+	s.InDelta(time.Now().Add(30*time.Second).Unix(), reset, 1)
+	s.Empty(w.Header().Get("Retry-After"))
+
+	// Draft headers are only sent when enabled
+	s.Empty(w.Header().Get("RateLimit"))
+	s.Empty(w.Header().Get("RateLimit-Policy"))
 }
 
-func (s *GetClientIPTestSuite) TestGetClientIP() {
-	tests := []struct {
-		name       string
-		headers    map[string]string
-		remoteAddr string
-		want       string
-	}{
-		{
-			name: "X-Forwarded-For header",
-			headers: map[string]string{
-				"X-Forwarded-For": "192.168.1.1",
-			},
-			remoteAddr: "10.0.0.1:1234",
-			want:       "192.168.1.1",
+func (s *MiddlewareTestSuite) TestRetryAfter() {
+	limiter := &mockLimiter{
+		decision: ratelimiter.Decision{
+			Limit:        ratelimiter.Limit{MaxRequests: 10, Window: time.Second},
+			ResetAt:      time.Now().Add(500 * time.Millisecond),
+			BlockedUntil: time.Now().Add(5 * time.Minute),
+			RetryAfter:   5 * time.Minute,
 		},
-		{
-			name: "X-Real-IP header",
-			headers: map[string]string{
-				"X-Real-IP": "192.168.1.2",
-			},
-			remoteAddr: "10.0.0.1:1234",
-			want:       "192.168.1.2",
-		},
-		{
-			name:       "RemoteAddr only",
-			headers:    map[string]string{},
-			remoteAddr: "192.168.1.3:1234",
-			want:       "192.168.1.3",
-		},
-		{
-			name: "Multiple IPs in X-Forwarded-For",
-			headers: map[string]string{
-				"X-Forwarded-For": "192.168.1.1, 10.0.0.1",
-			},
-			remoteAddr: "10.0.0.1:1234",
-			want:       "192.168.1.1",
-		},
-		{
-			name: "Empty X-Forwarded-For",
-			headers: map[string]string{
-				"X-Forwarded-For": "",
-			},
-			remoteAddr: "192.168.1.3:1234",
-			want:       "192.168.1.3",
-		},
-		{
-			name:       "Invalid RemoteAddr format",
-			headers:    map[string]string{},
-			remoteAddr: "invalid",
-			want:       "invalid",
+	}
+	middleware := New(limiter, s.config)
+
+	req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	w := httptest.NewRecorder()
+	middleware.Handler(s.nextHandler).ServeHTTP(w, req)
+
+	s.Equal(http.StatusTooManyRequests, w.Code)
+	s.Equal("0", w.Header().Get("X-RateLimit-Remaining"))
+	s.Equal("300", w.Header().Get("Retry-After"))
+}
+
+func (s *MiddlewareTestSuite) TestRetryAfterRoundsUp() {
+	limiter := &mockLimiter{
+		decision: ratelimiter.Decision{
+			Limit:   ratelimiter.Limit{MaxRequests: 10, Window: time.Second},
+			ResetAt:    time.Now().Add(200 * time.Millisecond),
+			RetryAfter: 200 * time.Millisecond,
 		},
 	}
+	middleware := New(limiter, s.config)
 
-	for _, tt := range tests {
-		s.Run(tt.name, func() {
-			req := httptest.NewRequest("GET", "http://example.com/foo", nil)
-			req.RemoteAddr = tt.remoteAddr
-			for k, v := range tt.headers {
-				req.Header.Set(k, v)
-			}
+	req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	w := httptest.NewRecorder()
+	middleware.Handler(s.nextHandler).ServeHTTP(w, req)
 
-			got := getClientIP(req)
-			s.Equal(tt.want, got, "getClientIP() returned unexpected value")
-		})
+	// Retry-After is rounded up to a whole second
+	s.Equal(http.StatusTooManyRequests, w.Code)
+	s.Equal("1", w.Header().Get("Retry-After"))
+}
+
+func (s *MiddlewareTestSuite) TestDraftHeaders() {
+	limiter := &mockLimiter{
+		allowed: true,
+		decision: ratelimiter.Decision{
+			Limit:     ratelimiter.Limit{Name: "daily", MaxRequests: 1000, Window: 24 * time.Hour},
+			Remaining: 998,
+			ResetAt:   time.Now().Add(time.Hour),
+		},
 	}
+	middleware := New(limiter, s.config, WithDraftHeaders())
+
+	req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	w := httptest.NewRecorder()
+	middleware.Handler(s.nextHandler).ServeHTTP(w, req)
+
+	s.Equal(`"daily";q=1000;w=86400`, w.Header().Get("RateLimit-Policy"))
+	s.Equal(`"daily";r=998;t=3600`, w.Header().Get("RateLimit"))
+}
+
+func (s *MiddlewareTestSuite) TestTokenRequest() {
+	limiter := &mockLimiter{allowed: true}
+	middleware := New(limiter, s.config)
+
+	req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Set("API_KEY", "test-token")
+	w := httptest.NewRecorder()
+	middleware.Handler(s.nextHandler).ServeHTTP(w, req)
+
+	s.Equal([]ratelimiter.Request{{Key: "test-token", IsToken: true}}, limiter.requests)
 }
 
 func TestMiddleware(t *testing.T) {
 	suite.Run(t, new(MiddlewareTestSuite))
-	suite.Run(t, new(GetClientIPTestSuite))
 }
\ No newline at end of file
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+)
+
+// RouteRule limits the requests matching a pattern separately from the rest
+type RouteRule struct {
+	// Name identifies the rule in decisions and storage keys. When empty,
+	// the pattern is used.
+	Name string
+
+	// Pattern is a ServeMux pattern, such as "POST /checkout",
+	// "GET /users/{id}" or "/static/"
+	Pattern string
+
+	// Limit is the limit of each client on the matching requests
+	Limit ratelimiter.TokenConfig
+}
+
+// rule returns the name the rule is registered under
+func (r RouteRule) rule() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Pattern
+}
+
+// WithRoutes makes requests matching a rule's pattern count against the
+// rule's limit, with their own counters, instead of the IP, token or key
+// limits. When several patterns match, the most specific one wins, as in
+// ServeMux. The limits are registered as rules in the middleware's config,
+// which the limiter must be built from: New panics when the limiter doesn't
+// know them.
+//
+// Like ServeMux.Handle, it panics on invalid or conflicting patterns, which
+// LoadRouteRules reports as errors instead.
+func WithRoutes(rules ...RouteRule) Option {
+	return func(m *RateLimiterMiddleware) {
+		routes, err := newRouteTable(append(m.routeRules(), rules...))
+		if err != nil {
+			panic(err)
+		}
+		m.routes = routes
+
+		for _, rule := range rules {
+			m.config.SetRule(rule.rule(), rule.Limit)
+		}
+	}
+}
+
+// routeTable matches requests to route rules
+type routeTable struct {
+	mux   *http.ServeMux
+	rules []RouteRule
+	names map[string]string // pattern to rule name
+}
+
+// newRouteTable registers the patterns of rules, reporting invalid or
+// conflicting patterns as errors
+func newRouteTable(rules []RouteRule) (table *routeTable, err error) {
+	table = &routeTable{
+		mux:   http.NewServeMux(),
+		rules: rules,
+		names: make(map[string]string, len(rules)),
+	}
+
+	// ServeMux panics on invalid patterns
+	defer func() {
+		if r := recover(); r != nil {
+			table, err = nil, fmt.Errorf("invalid route rule: %v", r)
+		}
+	}()
+
+	noop := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	for _, rule := range rules {
+		table.mux.Handle(rule.Pattern, noop)
+		table.names[rule.Pattern] = rule.rule()
+	}
+
+	return table, nil
+}
+
+// match returns the name of the rule whose pattern matches the request, or
+// false when none does
+func (t *routeTable) match(r *http.Request) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+
+	_, pattern := t.mux.Handler(r)
+	name, ok := t.names[pattern]
+	return name, ok
+}
+
+// routeRules returns the rules registered so far
+func (m *RateLimiterMiddleware) routeRules() []RouteRule {
+	if m.routes == nil {
+		return nil
+	}
+	return m.routes.rules
+}
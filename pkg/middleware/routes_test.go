@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/test"
+)
+
+func TestRouteRules(t *testing.T) {
+	store := test.NewMemoryStorage()
+	defer store.Close()
+
+	config := ratelimiter.NewConfig()
+	config.MaxRequestsPerSecond = 2
+	config.BlockDuration = time.Minute
+
+	limiter := ratelimiter.New(store, config)
+	middleware := New(limiter, config, WithRoutes(
+		RouteRule{Name: "checkout", Pattern: "POST /checkout", Limit: ratelimiter.TokenConfig{MaxRequestsPerSecond: 1, BlockDuration: time.Minute}},
+		RouteRule{Pattern: "GET /health", Limit: ratelimiter.TokenConfig{MaxRequestsPerSecond: 100, BlockDuration: time.Minute}},
+		RouteRule{Pattern: "GET /users/{id}", Limit: ratelimiter.TokenConfig{MaxRequestsPerSecond: 3, BlockDuration: time.Minute}},
+	))
+	handler := middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(method, path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Rules have their own limits", func(t *testing.T) {
+		addr := "192.168.1.1:1234"
+
+		if w := serve("POST", "/checkout", addr); w.Code != http.StatusOK {
+			t.Errorf("First checkout should pass, got %d", w.Code)
+		}
+		w := serve("POST", "/checkout", addr)
+		if w.Code != http.StatusTooManyRequests {
+			t.Errorf("Second checkout should be blocked, got %d", w.Code)
+		}
+		if limit := w.Header().Get("X-RateLimit-Limit"); limit != "1" {
+			t.Errorf("X-RateLimit-Limit = %s, want 1", limit)
+		}
+
+		// Other routes use separate counters
+		for i := 0; i < 5; i++ {
+			if w := serve("GET", "/health", addr); w.Code != http.StatusOK {
+				t.Errorf("Health check %d should pass, got %d", i+1, w.Code)
+			}
+		}
+		for i := 0; i < 2; i++ {
+			if w := serve("GET", "/other", addr); w.Code != http.StatusOK {
+				t.Errorf("Request %d to a route without rule should pass, got %d", i+1, w.Code)
+			}
+		}
+	})
+
+	t.Run("Methods are part of the pattern", func(t *testing.T) {
+		addr := "192.168.1.2:1234"
+
+		// GET /checkout has no rule and uses the default limit of 2
+		for i := 0; i < 2; i++ {
+			if w := serve("GET", "/checkout", addr); w.Code != http.StatusOK {
+				t.Errorf("Request %d should pass, got %d", i+1, w.Code)
+			}
+		}
+		if w := serve("GET", "/checkout", addr); w.Code != http.StatusTooManyRequests {
+			t.Errorf("Third request should be blocked, got %d", w.Code)
+		}
+	})
+
+	t.Run("Wildcards share one counter", func(t *testing.T) {
+		addr := "192.168.1.3:1234"
+
+		for _, path := range []string{"/users/1", "/users/2", "/users/3"} {
+			if w := serve("GET", path, addr); w.Code != http.StatusOK {
+				t.Errorf("GET %s should pass, got %d", path, w.Code)
+			}
+		}
+		if w := serve("GET", "/users/4", addr); w.Code != http.StatusTooManyRequests {
+			t.Errorf("Fourth user request should be blocked, got %d", w.Code)
+		}
+	})
+}
+
+func TestRouteRulesReportRule(t *testing.T) {
+	limiter := &mockLimiter{allowed: true}
+	config := ratelimiter.NewConfig()
+	middleware := New(limiter, config, WithRoutes(
+		RouteRule{Name: "checkout", Pattern: "POST /checkout", Limit: ratelimiter.TokenConfig{MaxRequestsPerSecond: 1}},
+	))
+	handler := middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("POST", "/checkout", nil)
+	req.Header.Set("API_KEY", "test-token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := ratelimiter.Request{Key: "test-token", IsToken: true, Rule: "checkout"}
+	if len(limiter.requests) != 1 || limiter.requests[0] != want {
+		t.Errorf("requests = %+v, want [%+v]", limiter.requests, want)
+	}
+	if _, ok := config.Rules["checkout"]; !ok {
+		t.Error("Expected the rule to be registered in the config")
+	}
+}
+
+func TestRouteRulesInvalidPattern(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic for conflicting patterns")
+		}
+	}()
+
+	New(&mockLimiter{}, ratelimiter.NewConfig(), WithRoutes(
+		RouteRule{Pattern: "GET /checkout"},
+		RouteRule{Pattern: "GET /checkout"},
+	))
+}
+
+func TestRouteRulesRequireLimiterConfig(t *testing.T) {
+	store := test.NewMemoryStorage()
+	defer store.Close()
+
+	rule := RouteRule{Name: "checkout", Pattern: "POST /checkout", Limit: ratelimiter.TokenConfig{MaxRequestsPerSecond: 1}}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("New() with a limiter built from another config should panic")
+		}
+	}()
+	New(ratelimiter.New(store, ratelimiter.NewConfig()), ratelimiter.NewConfig(), WithRoutes(rule))
+}
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+)
+
+// Default prefix lengths client IPs are truncated to. A single IPv6 host
+// usually gets a whole /64, so counting its addresses separately would let
+// it rotate through them for fresh quotas.
+const (
+	DefaultIPv4Prefix = 32
+	DefaultIPv6Prefix = 64
+)
+
+// SubnetConfig describes how client IPs are grouped into subnets before
+// being limited
+type SubnetConfig struct {
+	// IPv4Prefix is the prefix length IPv4 clients are truncated to before
+	// being counted against the IP limits (default: DefaultIPv4Prefix)
+	IPv4Prefix int
+
+	// IPv6Prefix is the prefix length IPv6 clients are truncated to before
+	// being counted against the IP limits (default: DefaultIPv6Prefix)
+	IPv6Prefix int
+
+	// Levels are wider subnets counted against their own limits, in
+	// addition to the IP limits
+	Levels []SubnetLevel
+}
+
+// SubnetLevel limits every subnet of one prefix length, such as each IPv4
+// /24 or each IPv6 /48
+type SubnetLevel struct {
+	// IPv6 selects the address family the level applies to
+	IPv6 bool
+
+	// Prefix is the prefix length of the subnets
+	Prefix int
+
+	// Limit is the limit of each subnet
+	Limit ratelimiter.TokenConfig
+}
+
+// WithSubnets sets how client IPs are grouped into subnets. The limits of
+// the subnet levels are registered as rules in the middleware's config,
+// which the limiter must be built from: New panics when the limiter doesn't
+// know them.
+func WithSubnets(subnets SubnetConfig) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.subnets = subnets
+		for _, level := range subnets.Levels {
+			m.config.SetRule(level.rule(), level.Limit)
+		}
+	}
+}
+
+// rule returns the name of the level's rule, such as "ipv6/48"
+func (l SubnetLevel) rule() string {
+	if l.IPv6 {
+		return fmt.Sprintf("ipv6/%d", l.Prefix)
+	}
+	return fmt.Sprintf("ipv4/%d", l.Prefix)
+}
+
+// key returns the IP limit key of a client address
+func (c SubnetConfig) key(addr netip.Addr) string {
+	bits := c.IPv4Prefix
+	if bits == 0 {
+		bits = DefaultIPv4Prefix
+	}
+	if addr.Is6() {
+		bits = c.IPv6Prefix
+		if bits == 0 {
+			bits = DefaultIPv6Prefix
+		}
+	}
+	return subnetKey(addr, bits)
+}
+
+// IPKey returns the key requests from a client IP are counted against under
+// rule, such as "2001:db8::/64" for "2001:db8::1" with the default IPv6
+// prefix. The rules of subnet levels use the prefix of their level. Keys
+// that are not IP addresses are returned as is.
+func (c SubnetConfig) IPKey(ip, rule string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+
+	for _, level := range c.Levels {
+		if level.IPv6 == addr.Is6() && level.rule() == rule {
+			return subnetKey(addr, level.Prefix)
+		}
+	}
+	return c.key(addr)
+}
+
+// ipKey returns the IP limit key of a client IP, which is the IP itself when
+// it can't be parsed
+func (m *RateLimiterMiddleware) ipKey(ip string) string {
+	return m.subnets.IPKey(ip, "")
+}
+
+// subnetKey returns the subnet of addr with the given prefix length, such as
+// "2001:db8::/64". A full length prefix is the address itself.
+func subnetKey(addr netip.Addr, bits int) string {
+	if bits >= addr.BitLen() {
+		return addr.WithZone("").String()
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr.WithZone("").String()
+	}
+	return prefix.String()
+}
+
+// allowIP checks an IP-based request costing cost units against the IP
+// limits, or those of rule when set, and then against every subnet level of
+// its address family.
+// Levels are checked in order and the first denial is reported; otherwise
+// the most constrained decision is.
+//
+// The checks are not atomic across levels: a request denied by a level has
+// still been counted by the ones before it.
+func (m *RateLimiterMiddleware) allowIP(ctx context.Context, limiter ratelimiter.RateLimiterInterface, ip, rule string, cost int) (ratelimiter.Decision, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		// Not an IP address, limit it as is
+		return limiter.Allow(ctx, ratelimiter.Request{Key: ip, Rule: rule, Cost: cost})
+	}
+	addr = addr.Unmap()
+
+	decision, err := limiter.Allow(ctx, ratelimiter.Request{Key: m.subnets.key(addr), Rule: rule, Cost: cost})
+	if err != nil || !decision.Allowed {
+		return decision, err
+	}
+
+	for _, level := range m.subnets.Levels {
+		if level.IPv6 != addr.Is6() {
+			continue
+		}
+
+		levelDecision, err := limiter.Allow(ctx, ratelimiter.Request{
+			Key:  subnetKey(addr, level.Prefix),
+			Rule: level.rule(),
+			Cost: cost,
+		})
+		if err != nil || !levelDecision.Allowed {
+			return levelDecision, err
+		}
+		if levelDecision.Remaining < decision.Remaining {
+			decision = levelDecision
+		}
+	}
+
+	return decision, nil
+}
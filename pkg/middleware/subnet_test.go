@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/test"
+)
+
+func TestSubnetKey(t *testing.T) {
+	tests := []struct {
+		name string
+		addr string
+		bits int
+		want string
+	}{
+		{"IPv4 address", "192.168.1.10", 32, "192.168.1.10"},
+		{"IPv4 subnet", "192.168.1.10", 24, "192.168.1.0/24"},
+		{"IPv6 address", "2001:db8::1", 128, "2001:db8::1"},
+		{"IPv6 subnet", "2001:db8:0:1:aaaa::1", 64, "2001:db8:0:1::/64"},
+		{"IPv6 zone", "fe80::1%eth0", 128, "fe80::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := subnetKey(netip.MustParseAddr(tt.addr), tt.bits)
+			if got != tt.want {
+				t.Errorf("subnetKey(%s, %d) = %s, want %s", tt.addr, tt.bits, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubnetAggregation(t *testing.T) {
+	newHandler := func(subnets SubnetConfig) http.Handler {
+		store := test.NewMemoryStorage()
+		t.Cleanup(func() { store.Close() })
+
+		config := ratelimiter.NewConfig()
+		config.MaxRequestsPerSecond = 2
+		config.BlockDuration = time.Minute
+
+		limiter := ratelimiter.New(store, config)
+		middleware := New(limiter, config, WithSubnets(subnets))
+		return middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+
+	serve := func(handler http.Handler, remoteAddr string) int {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("IPv6 addresses share their /64 by default", func(t *testing.T) {
+		handler := newHandler(SubnetConfig{})
+
+		for _, addr := range []string{"[2001:db8::1]:1234", "[2001:db8::2]:1234"} {
+			if code := serve(handler, addr); code != http.StatusOK {
+				t.Errorf("Request from %s should pass, got %d", addr, code)
+			}
+		}
+		if code := serve(handler, "[2001:db8::3]:1234"); code != http.StatusTooManyRequests {
+			t.Errorf("Third request from the same /64 should be blocked, got %d", code)
+		}
+
+		// Another /64 has its own quota
+		if code := serve(handler, "[2001:db8:0:1::1]:1234"); code != http.StatusOK {
+			t.Errorf("Request from another /64 should pass, got %d", code)
+		}
+	})
+
+	t.Run("IPv4 addresses are counted separately by default", func(t *testing.T) {
+		handler := newHandler(SubnetConfig{})
+
+		for _, addr := range []string{"192.168.1.1:1234", "192.168.1.2:1234", "192.168.1.3:1234"} {
+			if code := serve(handler, addr); code != http.StatusOK {
+				t.Errorf("Request from %s should pass, got %d", addr, code)
+			}
+		}
+	})
+
+	t.Run("Subnet levels have their own limits", func(t *testing.T) {
+		handler := newHandler(SubnetConfig{
+			Levels: []SubnetLevel{
+				{Prefix: 24, Limit: ratelimiter.TokenConfig{MaxRequestsPerSecond: 3, BlockDuration: time.Minute}},
+			},
+		})
+
+		for _, addr := range []string{"10.1.2.1:1234", "10.1.2.2:1234", "10.1.2.3:1234"} {
+			if code := serve(handler, addr); code != http.StatusOK {
+				t.Errorf("Request from %s should pass, got %d", addr, code)
+			}
+		}
+
+		// Each address is within its own limit, but the /24 is exhausted
+		if code := serve(handler, "10.1.2.4:1234"); code != http.StatusTooManyRequests {
+			t.Errorf("Fourth request from the same /24 should be blocked, got %d", code)
+		}
+		if code := serve(handler, "10.1.3.1:1234"); code != http.StatusOK {
+			t.Errorf("Request from another /24 should pass, got %d", code)
+		}
+
+		// IPv4 levels don't apply to IPv6 clients
+		for i := 0; i < 2; i++ {
+			if code := serve(handler, "[2001:db8::1]:1234"); code != http.StatusOK {
+				t.Errorf("IPv6 request %d should pass, got %d", i+1, code)
+			}
+		}
+	})
+}
+
+func TestSubnetLevelsRequireLimiterConfig(t *testing.T) {
+	store := test.NewMemoryStorage()
+	defer store.Close()
+
+	subnets := SubnetConfig{Levels: []SubnetLevel{
+		{Prefix: 24, Limit: ratelimiter.TokenConfig{MaxRequestsPerSecond: 10}},
+	}}
+
+	// A limiter built from the middleware's config knows the level rules
+	config := ratelimiter.NewConfig()
+	New(ratelimiter.New(store, config), config, WithSubnets(subnets))
+
+	// One built from another config would fail every request
+	defer func() {
+		if recover() == nil {
+			t.Error("New() with a limiter built from another config should panic")
+		}
+	}()
+	New(ratelimiter.New(store, ratelimiter.NewConfig()), ratelimiter.NewConfig(), WithSubnets(subnets))
+}
+
+func TestSubnetConfigIPKey(t *testing.T) {
+	subnets := SubnetConfig{Levels: []SubnetLevel{{IPv6: true, Prefix: 48}}}
+
+	tests := []struct {
+		ip, rule, want string
+	}{
+		{"192.168.1.10", "", "192.168.1.10"},
+		{"::ffff:192.168.1.10", "", "192.168.1.10"},
+		{"2001:db8:0:1::1", "", "2001:db8:0:1::/64"},
+		{"2001:db8:0:1::1", "ipv6/48", "2001:db8::/48"},
+		{"2001:db8::/64", "", "2001:db8::/64"},
+		{"api-key-123", "", "api-key-123"},
+	}
+
+	for _, tt := range tests {
+		if got := subnets.IPKey(tt.ip, tt.rule); got != tt.want {
+			t.Errorf("IPKey(%q, %q) = %q, want %q", tt.ip, tt.rule, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+)
+
+// errUnknownToken reports a request rejected by the UnknownTokenReject policy
+var errUnknownToken = errors.New("unknown API token")
+
+// TokenValidator decides whether a request's token is known, e.g. by
+// looking it up in a database of issued API keys
+type TokenValidator interface {
+	// ValidateToken reports whether token is known. It is called once per
+	// request. Errors are handled like rate limiter errors: they reach the
+	// error hooks and the failure policy decides the request, which gets a
+	// 500 under FailClosed. Under FailLocal the fallback limiter limits the
+	// request like one without a token.
+	ValidateToken(ctx context.Context, token string) (bool, error)
+}
+
+// TokenValidatorFunc adapts a function to the TokenValidator interface
+type TokenValidatorFunc func(ctx context.Context, token string) (bool, error)
+
+// ValidateToken calls f(ctx, token)
+func (f TokenValidatorFunc) ValidateToken(ctx context.Context, token string) (bool, error) {
+	return f(ctx, token)
+}
+
+// WithTokenValidator makes the middleware consult validator, instead of the
+// config's TokenLimits, to decide which tokens are known. Known tokens without
+// limits of their own get the default limits. Unknown tokens are handled by
+// the config's UnknownTokens policy, so the validator is not consulted under
+// UnknownTokenDefault.
+func WithTokenValidator(validator TokenValidator) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.tokenValidator = validator
+	}
+}
+
+// resolveToken returns the token of a request and whether it is known. Under
+// the UnknownTokenDefault policy every token is treated as known without
+// consulting the validator.
+func (m *RateLimiterMiddleware) resolveToken(r *http.Request) (string, bool, error) {
+	token := r.Header.Get(m.config.TokenHeader)
+	if token == "" {
+		return "", false, nil
+	}
+
+	policy := m.config.UnknownTokens
+	if policy == "" || policy == ratelimiter.UnknownTokenDefault {
+		return token, true, nil
+	}
+
+	known, err := m.knownToken(r.Context(), token)
+	return token, known, err
+}
+
+// allowToken checks a request with a token according to the unknown token
+// policy. It returns false when the request should be limited like one
+// without a token, and errUnknownToken when it should be rejected.
+func (m *RateLimiterMiddleware) allowToken(r *http.Request, limiter ratelimiter.RateLimiterInterface, token string, known bool, rule string, cost int) (ratelimiter.Decision, bool, error) {
+	ctx := r.Context()
+	if known {
+		decision, err := limiter.Allow(ctx, ratelimiter.Request{Key: token, IsToken: true, Rule: rule, Cost: cost})
+		return decision, true, err
+	}
+
+	switch m.config.UnknownTokens {
+	case ratelimiter.UnknownTokenReject:
+		return ratelimiter.Decision{}, true, errUnknownToken
+	case ratelimiter.UnknownTokenLimit:
+		// Routes with a rule keep their limit, counted by client IP
+		if rule == "" {
+			rule = ratelimiter.RuleUnknownToken
+		}
+		ip := m.ipKey(m.clientIP(r))
+		decision, err := limiter.Allow(ctx, ratelimiter.Request{Key: ip, Rule: rule, Cost: cost})
+		return decision, true, err
+	default:
+		return ratelimiter.Decision{}, false, nil
+	}
+}
+
+// knownToken reports whether a token is known to the validator or, without
+// one, listed in the config's TokenLimits
+func (m *RateLimiterMiddleware) knownToken(ctx context.Context, token string) (bool, error) {
+	if m.tokenValidator != nil {
+		return m.tokenValidator.ValidateToken(ctx, token)
+	}
+	return m.config.IsKnownToken(token), nil
+}
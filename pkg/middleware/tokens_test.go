@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/test"
+)
+
+func TestUnknownTokenPolicies(t *testing.T) {
+	newHandler := func(policy ratelimiter.UnknownTokenPolicy, opts ...Option) http.Handler {
+		config := ratelimiter.NewConfig()
+		config.MaxRequestsPerSecond = 2
+		config.BlockDuration = time.Minute
+		config.UnknownTokens = policy
+		config.UnknownTokenLimit = ratelimiter.TokenConfig{MaxRequestsPerSecond: 1, BlockDuration: time.Minute}
+		config.SetTokenLimit("known", 5, time.Minute)
+
+		limiter := ratelimiter.New(test.NewMemoryStorage(), config)
+		return New(limiter, config, opts...).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+
+	serve := func(handler http.Handler, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.168.1.1:1234"
+		if token != "" {
+			req.Header.Set("API_KEY", token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Default gives every token its own counter", func(t *testing.T) {
+		handler := newHandler(ratelimiter.UnknownTokenDefault)
+		for i := 0; i < 5; i++ {
+			if w := serve(handler, "random-"+strconv.Itoa(i)); w.Code != http.StatusOK {
+				t.Errorf("Request %d should pass, got %d", i+1, w.Code)
+			}
+		}
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		handler := newHandler(ratelimiter.UnknownTokenReject)
+		if w := serve(handler, "random"); w.Code != http.StatusUnauthorized {
+			t.Errorf("Unknown token should be rejected, got %d", w.Code)
+		}
+		if w := serve(handler, "known"); w.Code != http.StatusOK {
+			t.Errorf("Known token should pass, got %d", w.Code)
+		}
+		if w := serve(handler, ""); w.Code != http.StatusOK {
+			t.Errorf("Request without token should pass, got %d", w.Code)
+		}
+	})
+
+	t.Run("IP shares the client IP counter", func(t *testing.T) {
+		handler := newHandler(ratelimiter.UnknownTokenIP)
+		if w := serve(handler, "random-a"); w.Code != http.StatusOK {
+			t.Errorf("First request should pass, got %d", w.Code)
+		}
+		if w := serve(handler, ""); w.Code != http.StatusOK {
+			t.Errorf("Second request should pass, got %d", w.Code)
+		}
+		if w := serve(handler, "random-b"); w.Code != http.StatusTooManyRequests {
+			t.Errorf("Third request should be blocked, got %d", w.Code)
+		}
+	})
+
+	t.Run("Limit counts unknown tokens by client IP", func(t *testing.T) {
+		handler := newHandler(ratelimiter.UnknownTokenLimit)
+		if w := serve(handler, "random-a"); w.Code != http.StatusOK {
+			t.Errorf("First unknown token should pass, got %d", w.Code)
+		}
+		if w := serve(handler, "random-b"); w.Code != http.StatusTooManyRequests {
+			t.Errorf("Second unknown token should be blocked, got %d", w.Code)
+		}
+		// Requests without a token keep their own counter
+		if w := serve(handler, ""); w.Code != http.StatusOK {
+			t.Errorf("Request without token should pass, got %d", w.Code)
+		}
+	})
+
+	t.Run("Validator decides which tokens are known", func(t *testing.T) {
+		validator := TokenValidatorFunc(func(ctx context.Context, token string) (bool, error) {
+			return token == "issued", nil
+		})
+		handler := newHandler(ratelimiter.UnknownTokenReject, WithTokenValidator(validator))
+		if w := serve(handler, "issued"); w.Code != http.StatusOK {
+			t.Errorf("Valid token should pass, got %d", w.Code)
+		}
+		if w := serve(handler, "known"); w.Code != http.StatusUnauthorized {
+			t.Errorf("Token rejected by the validator should be rejected, got %d", w.Code)
+		}
+	})
+
+	t.Run("Validator errors", func(t *testing.T) {
+		validator := TokenValidatorFunc(func(ctx context.Context, token string) (bool, error) {
+			return false, errors.New("database unavailable")
+		})
+		handler := newHandler(ratelimiter.UnknownTokenReject, WithTokenValidator(validator))
+		if w := serve(handler, "issued"); w.Code != http.StatusInternalServerError {
+			t.Errorf("Validator error should fail the request, got %d", w.Code)
+		}
+	})
+
+	t.Run("Validator errors under FailLocal", func(t *testing.T) {
+		var calls, reported int
+		validator := TokenValidatorFunc(func(ctx context.Context, token string) (bool, error) {
+			calls++
+			return false, errors.New("database unavailable")
+		})
+		handler := newHandler(ratelimiter.UnknownTokenReject, WithTokenValidator(validator),
+			WithFailurePolicy(FailLocal),
+			WithErrorHook(func(r *http.Request, err error) { reported++ }))
+
+		// The fallback limits the request by client IP without validating
+		// the token again
+		for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+			if w := serve(handler, "issued-"+strconv.Itoa(i)); w.Code != want {
+				t.Errorf("Request %d got %d, want %d", i+1, w.Code, want)
+			}
+		}
+		if calls != 3 || reported != 3 {
+			t.Errorf("Validator called %d times and %d errors reported, want 3 and 3", calls, reported)
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingPropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	store := storage.NewMemoryStorage(storage.MemoryOptions{})
+	defer store.Close()
+	config := ratelimiter.NewConfig()
+	config.MaxRequestsPerSecond = 10
+	limiter := ratelimiter.New(store, config, ratelimiter.WithTracerProvider(provider))
+
+	handler := New(limiter, config).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// The span of the server, e.g. started by otelhttp
+	req := httptest.NewRequest("GET", "/", nil)
+	ctx, server := provider.Tracer("test").Start(req.Context(), "GET /")
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+	server.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != "ratelimiter.Allow" {
+		t.Fatalf("Got spans %v, want ratelimiter.Allow and the server span", spans)
+	}
+	if spans[0].Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("The limiter span should be a child of the request's span")
+	}
+}
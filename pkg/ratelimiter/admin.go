@@ -0,0 +1,158 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoBlockDuration is returned by Block when neither the call nor the
+// limit of the request sets a block duration
+var ErrNoBlockDuration = errors.New("no block duration")
+
+// KeyStatus describes the state of a key in storage
+type KeyStatus struct {
+	// Key is the storage key, which is hashed when Config.KeyHasher is set
+	Key string
+
+	// Rule is the name of the rule whose limits apply to the key
+	Rule string
+
+	// Limit is the primary limit of the rule
+	Limit Limit
+
+	// Count is the number of units counted in the key's current fixed
+	// window. Keys limited by other algorithms report zero.
+	Count int
+
+	// Remaining is the number of units left in the current fixed window
+	Remaining int
+
+	// ResetAfter is the time left in the current fixed window
+	ResetAfter time.Duration
+
+	// BlockTTL is the time left on the key's block, or zero when the key is
+	// not blocked
+	BlockTTL time.Duration
+}
+
+// Blocked reports whether the key is blocked
+func (s KeyStatus) Blocked() bool {
+	return s.BlockTTL > 0
+}
+
+// BlockedKey is a key listed by ListBlocked
+type BlockedKey struct {
+	// StorageKey is the key in storage
+	StorageKey string
+
+	// Request is the request the key is counted for, which Status, Block,
+	// Unblock and Reset accept. It is empty for storage keys the limiter
+	// doesn't know the format of.
+	Request Request
+
+	// Hashed reports whether Config.KeyHasher hashed the key, in which case
+	// Request.Key is the hash and the client key is needed to select it
+	Hashed bool
+
+	// TTL is the time left on the block
+	TTL time.Duration
+}
+
+// Status returns the state of the key a request would be counted against,
+// without counting it
+func (r *RateLimiter) Status(ctx context.Context, req Request) (KeyStatus, error) {
+	limit, rule, err := r.limitForRequest(req)
+	if err != nil {
+		return KeyStatus{}, err
+	}
+
+	key := req.storageKey(r.config.KeyHasher)
+	count, err := r.storage.GetRequestCount(ctx, key)
+	if err != nil {
+		return KeyStatus{}, fmt.Errorf("failed to get request count: %w", err)
+	}
+	windowTTL, err := r.storage.GetWindowTTL(ctx, key)
+	if err != nil {
+		return KeyStatus{}, fmt.Errorf("failed to get window expiration: %w", err)
+	}
+	blockTTL, err := r.storage.GetBlockTTL(ctx, key)
+	if err != nil {
+		return KeyStatus{}, fmt.Errorf("failed to get block expiration: %w", err)
+	}
+
+	return KeyStatus{
+		Key:        key,
+		Rule:       rule,
+		Limit:      limit.primary(),
+		Count:      int(count),
+		Remaining:  max(0, limit.MaxRequestsPerSecond-int(count)),
+		ResetAfter: windowTTL,
+		BlockTTL:   blockTTL,
+	}, nil
+}
+
+// Block blocks the key of a request for duration, or for the block duration
+// of its limit when duration is zero
+func (r *RateLimiter) Block(ctx context.Context, req Request, duration time.Duration) error {
+	limit, _, err := r.limitForRequest(req)
+	if err != nil {
+		return err
+	}
+	if duration <= 0 {
+		duration = limit.BlockDuration
+	}
+	if duration <= 0 {
+		return ErrNoBlockDuration
+	}
+
+	if err := r.storage.Block(ctx, req.storageKey(r.config.KeyHasher), duration); err != nil {
+		return fmt.Errorf("failed to block key: %w", err)
+	}
+	return nil
+}
+
+// Unblock lifts the block of the key of a request, if any
+func (r *RateLimiter) Unblock(ctx context.Context, req Request) error {
+	if _, _, err := r.limitForRequest(req); err != nil {
+		return err
+	}
+	if err := r.storage.Unblock(ctx, req.storageKey(r.config.KeyHasher)); err != nil {
+		return fmt.Errorf("failed to unblock key: %w", err)
+	}
+	return nil
+}
+
+// Reset clears the counters of the key of a request. A block is kept; lift
+// it with Unblock.
+func (r *RateLimiter) Reset(ctx context.Context, req Request) error {
+	limit, _, err := r.limitForRequest(req)
+	if err != nil {
+		return err
+	}
+	quotas := storageQuotas(limit.limits())
+	if err := r.storage.Reset(ctx, req.storageKey(r.config.KeyHasher), quotas); err != nil {
+		return fmt.Errorf("failed to reset key: %w", err)
+	}
+	return nil
+}
+
+// ListBlocked returns up to limit blocked keys, or all of them when limit is
+// zero
+func (r *RateLimiter) ListBlocked(ctx context.Context, limit int) ([]BlockedKey, error) {
+	blocked, err := r.storage.ListBlocked(ctx, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blocked keys: %w", err)
+	}
+
+	keys := make([]BlockedKey, len(blocked))
+	for i, b := range blocked {
+		keys[i] = BlockedKey{StorageKey: b.Key, TTL: b.TTL}
+		if req, ok := parseStorageKey(b.Key); ok {
+			keys[i].Request = req
+			keys[i].Hashed = r.config.KeyHasher != nil
+		}
+	}
+	return keys, nil
+}
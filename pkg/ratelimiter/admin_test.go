@@ -0,0 +1,179 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
+)
+
+func newAdminTestLimiter(t *testing.T) *RateLimiter {
+	t.Helper()
+
+	store := storage.NewMemoryStorage(storage.MemoryOptions{})
+	t.Cleanup(func() { store.Close() })
+	config := &Config{
+		MaxRequestsPerSecond: 2,
+		BlockDuration:        time.Minute,
+		Rules:                map[string]TokenConfig{"export": {MaxRequestsPerSecond: 1}},
+	}
+	return New(store, config)
+}
+
+func TestStatus(t *testing.T) {
+	limiter := newAdminTestLimiter(t)
+	ctx := context.Background()
+	req := Request{Key: "10.0.0.1"}
+
+	for i := 0; i < 3; i++ {
+		if _, err := limiter.Allow(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, err := limiter.Status(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Key != "ip:10.0.0.1" || status.Rule != RuleDefault {
+		t.Errorf("Status() key = %q, rule = %q, want ip:10.0.0.1 and %q", status.Key, status.Rule, RuleDefault)
+	}
+	// The denied request is not counted
+	if status.Count != 2 || status.Remaining != 0 {
+		t.Errorf("Status() count = %d, remaining = %d, want 2 and 0", status.Count, status.Remaining)
+	}
+	if !status.Blocked() || status.BlockTTL > time.Minute {
+		t.Errorf("Status() block TTL = %v, want up to a minute", status.BlockTTL)
+	}
+
+	// Checking the status doesn't count requests
+	again, err := limiter.Status(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Count != status.Count {
+		t.Errorf("Status() count = %d after a second call, want %d", again.Count, status.Count)
+	}
+}
+
+func TestUnblockAndReset(t *testing.T) {
+	limiter := newAdminTestLimiter(t)
+	ctx := context.Background()
+	req := Request{Key: "10.0.0.1"}
+
+	for i := 0; i < 3; i++ {
+		if _, err := limiter.Allow(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := limiter.Reset(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	decision, err := limiter.Allow(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Reason != ReasonBlocked {
+		t.Errorf("Allow() after Reset() reason = %q, want %q", decision.Reason, ReasonBlocked)
+	}
+
+	if err := limiter.Unblock(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	decision, err = limiter.Allow(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decision.Allowed {
+		t.Errorf("Allow() after Unblock() = %+v, want allowed", decision)
+	}
+}
+
+func TestBlock(t *testing.T) {
+	limiter := newAdminTestLimiter(t)
+	ctx := context.Background()
+
+	if err := limiter.Block(ctx, Request{Key: "abc", IsToken: true}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Block(ctx, Request{Key: "10.0.0.1"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := limiter.Status(ctx, Request{Key: "abc", IsToken: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.BlockTTL <= 0 || status.BlockTTL > time.Minute {
+		t.Errorf("Status() block TTL = %v, want the default block duration", status.BlockTTL)
+	}
+
+	blocked, err := limiter.ListBlocked(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocked) != 2 {
+		t.Errorf("ListBlocked() = %v, want 2 keys", blocked)
+	}
+	for _, b := range blocked {
+		if (b.Request.Key != "abc" && b.Request.Key != "10.0.0.1") || b.Hashed || b.TTL <= 0 {
+			t.Errorf("ListBlocked() item = %+v, want the request of the key", b)
+		}
+	}
+
+	// The export rule has no block duration
+	err = limiter.Block(ctx, Request{Key: "10.0.0.1", Rule: "export"}, 0)
+	if !errors.Is(err, ErrNoBlockDuration) {
+		t.Errorf("Block() without duration error = %v, want ErrNoBlockDuration", err)
+	}
+	err = limiter.Block(ctx, Request{Key: "10.0.0.1", Rule: "missing"}, time.Minute)
+	if !errors.Is(err, ErrUnknownRule) {
+		t.Errorf("Block() with an unknown rule error = %v, want ErrUnknownRule", err)
+	}
+}
+
+func TestListBlockedHashedKeys(t *testing.T) {
+	store := storage.NewMemoryStorage(storage.MemoryOptions{})
+	defer store.Close()
+	config := &Config{
+		MaxRequestsPerSecond: 2,
+		BlockDuration:        time.Minute,
+		KeyHasher:            NewHMACKeyHasher([]byte("secret")),
+	}
+	limiter := New(store, config)
+	ctx := context.Background()
+
+	req := Request{Key: "abc", IsToken: true}
+	if err := limiter.Block(ctx, req, 0); err != nil {
+		t.Fatal(err)
+	}
+	blocked, err := limiter.ListBlocked(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocked) != 1 || !blocked[0].Hashed || !blocked[0].Request.IsToken ||
+		blocked[0].Request.Key != config.KeyHasher.HashKey("token:abc") {
+		t.Errorf("ListBlocked() = %+v, want the hashed token key", blocked)
+	}
+}
+
+func TestResetPassesQuotas(t *testing.T) {
+	store := new(MockStorage)
+	config := &Config{
+		MaxRequestsPerSecond: 2,
+		Limits:               []Limit{{MaxRequests: 100, Window: time.Hour}},
+	}
+	limiter := New(store, config)
+	ctx := context.Background()
+
+	quotas := []storage.Quota{{Max: 2, Window: time.Second}, {Max: 100, Window: time.Hour}}
+	store.On("Reset", ctx, "ip:10.0.0.1", quotas).Return(nil)
+
+	if err := limiter.Reset(ctx, Request{Key: "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	store.AssertExpectations(t)
+}
@@ -0,0 +1,39 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Algorithm identifies the rate limiting algorithm applied to a key
+type Algorithm string
+
+const (
+	// FixedWindow counts requests in consecutive fixed windows of the
+	// configured Window (default)
+	FixedWindow Algorithm = "fixed_window"
+
+	// TokenBucket refills a bucket of Burst tokens with MaxRequestsPerSecond
+	// tokens per Window, allowing short bursts over a steady average rate
+	TokenBucket Algorithm = "token_bucket"
+
+	// SlidingWindowLog records the timestamp of every request and counts those
+	// inside the trailing window, giving exact rolling-window semantics
+	SlidingWindowLog Algorithm = "sliding_window_log"
+
+	// SlidingWindowCounter approximates a rolling window by weighting the
+	// previous fixed window's count by how much of it still overlaps
+	SlidingWindowCounter Algorithm = "sliding_window_counter"
+)
+
+// ParseAlgorithm converts a string such as "token_bucket" into an Algorithm
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch a := Algorithm(strings.ToLower(strings.TrimSpace(s))); a {
+	case FixedWindow, TokenBucket, SlidingWindowLog, SlidingWindowCounter:
+		return a, nil
+	case "":
+		return FixedWindow, nil
+	default:
+		return "", fmt.Errorf("unknown rate limiting algorithm %q", s)
+	}
+}
@@ -0,0 +1,121 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	defaultAsyncQueueSize = 1024
+	defaultAsyncWorkers   = 1
+)
+
+// AsyncOptions configures an AsyncListener
+type AsyncOptions struct {
+	// QueueSize bounds the number of events waiting for the listener
+	// (default: 1024). Events that don't fit are dropped.
+	QueueSize int
+
+	// Workers is the number of goroutines calling the listener (default: 1).
+	// With more than one, events may be delivered out of order.
+	Workers int
+}
+
+// AsyncListener passes events to another listener from background
+// goroutines, so that a slow listener, such as one publishing to a queue,
+// can't stall requests. Events are dropped instead of waiting when the queue
+// is full.
+type AsyncListener struct {
+	listener Listener
+	queue    chan func()
+	dropped  atomic.Uint64
+	wg       sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewAsyncListener starts the workers that pass events to listener. Call
+// Close to deliver the queued events and stop them.
+func NewAsyncListener(listener Listener, opts AsyncOptions) *AsyncListener {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultAsyncQueueSize
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = defaultAsyncWorkers
+	}
+
+	a := &AsyncListener{
+		listener: listener,
+		queue:    make(chan func(), opts.QueueSize),
+	}
+	a.wg.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go a.work()
+	}
+	return a
+}
+
+func (a *AsyncListener) OnAllow(ctx context.Context, event Event) {
+	ctx = context.WithoutCancel(ctx)
+	a.dispatch(func() { a.listener.OnAllow(ctx, event) })
+}
+
+func (a *AsyncListener) OnDeny(ctx context.Context, event Event) {
+	ctx = context.WithoutCancel(ctx)
+	a.dispatch(func() { a.listener.OnDeny(ctx, event) })
+}
+
+func (a *AsyncListener) OnBlock(ctx context.Context, event Event) {
+	ctx = context.WithoutCancel(ctx)
+	a.dispatch(func() { a.listener.OnBlock(ctx, event) })
+}
+
+func (a *AsyncListener) OnError(ctx context.Context, event ErrorEvent) {
+	ctx = context.WithoutCancel(ctx)
+	a.dispatch(func() { a.listener.OnError(ctx, event) })
+}
+
+// Dropped returns the number of events dropped because the queue was full
+// or the listener closed
+func (a *AsyncListener) Dropped() uint64 {
+	return a.dropped.Load()
+}
+
+// Close delivers the queued events and stops the workers. Later events are
+// dropped.
+func (a *AsyncListener) Close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+
+	a.wg.Wait()
+}
+
+// dispatch queues a call to the listener, or drops it when the queue is full
+func (a *AsyncListener) dispatch(call func()) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		a.dropped.Add(1)
+		return
+	}
+	select {
+	case a.queue <- call:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// work calls the listener with queued events until the queue is closed
+func (a *AsyncListener) work() {
+	defer a.wg.Done()
+	for call := range a.queue {
+		call()
+	}
+}
@@ -1,6 +1,7 @@
 package ratelimiter
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
@@ -9,9 +10,13 @@ import (
 
 // Config holds the rate limiter configuration
 type Config struct {
-	// MaxRequestsPerSecond is the maximum number of requests allowed per second
+	// MaxRequestsPerSecond is the maximum number of requests allowed per
+	// Window. The name predates Window, which defaults to one second.
 	MaxRequestsPerSecond int
 
+	// Window is the period MaxRequestsPerSecond applies to (default: one second)
+	Window time.Duration
+
 	// BlockDuration is how long to block after exceeding the limit
 	BlockDuration time.Duration
 
@@ -20,21 +25,92 @@ type Config struct {
 
 	// TokenLimits holds specific limits for tokens
 	TokenLimits map[string]TokenConfig
+
+	// Algorithm is the rate limiting algorithm (default: FixedWindow)
+	Algorithm Algorithm
+
+	// Burst is the token bucket capacity used by the TokenBucket algorithm.
+	// When zero, the bucket holds MaxRequestsPerSecond tokens.
+	Burst int
+
+	// Limits are additional quotas enforced together with
+	// MaxRequestsPerSecond, e.g. 500 per minute and 10000 per day
+	Limits []Limit
+
+	// Rules holds named limits that requests select with Request.Rule,
+	// instead of the IP or token limits. Each rule counts requests
+	// separately.
+	Rules map[string]TokenConfig
+
+	// UnknownTokens decides how requests with a token that is not listed in
+	// TokenLimits are limited (default: UnknownTokenDefault)
+	UnknownTokens UnknownTokenPolicy
+
+	// UnknownTokenLimit is the limit of each client IP on requests with
+	// unknown tokens under the UnknownTokenLimit policy. Requests select it
+	// with the RuleUnknownToken rule.
+	UnknownTokenLimit TokenConfig
+
+	// KeyHasher hashes IPs and tokens before they are used in storage keys,
+	// e.g. NewHMACKeyHasher. When nil, they are stored in clear text.
+	KeyHasher KeyHasher
 }
 
 // TokenConfig holds configuration for specific tokens
 type TokenConfig struct {
 	MaxRequestsPerSecond int
-	BlockDuration       time.Duration
+	BlockDuration        time.Duration
+
+	// Window is the period MaxRequestsPerSecond applies to for the token.
+	// When zero, Config.Window is used.
+	Window time.Duration
+
+	// Algorithm overrides Config.Algorithm for the token when set
+	Algorithm Algorithm
+
+	// Burst is the token bucket capacity for the token. When zero, the
+	// bucket holds MaxRequestsPerSecond tokens.
+	Burst int
+
+	// Limits are additional quotas enforced together with
+	// MaxRequestsPerSecond for the token
+	Limits []Limit
+}
+
+// Limit is a quota of MaxRequests per Window. A key with several limits is
+// denied as soon as any of them is exhausted.
+//
+// Keys with additional limits are counted with fixed windows, regardless of
+// the configured Algorithm, so that all counters are updated atomically.
+type Limit struct {
+	// Name identifies the limit in decisions (optional)
+	Name string
+
+	MaxRequests int
+
+	// Window is the period MaxRequests applies to. When zero, Config.Window
+	// is used.
+	Window time.Duration
+}
+
+// String returns the limit's name, or "<requests>/<window>" when unnamed
+func (l Limit) String() string {
+	if l.Name != "" {
+		return l.Name
+	}
+	return fmt.Sprintf("%d/%v", l.MaxRequests, l.Window)
 }
 
 // NewConfig creates a new rate limiter configuration with default values
 func NewConfig() *Config {
 	return &Config{
 		MaxRequestsPerSecond: 10,
-		BlockDuration:       time.Minute * 5,
-		TokenHeader:        "API_KEY",
-		TokenLimits:       make(map[string]TokenConfig),
+		BlockDuration:        time.Minute * 5,
+		TokenHeader:          "API_KEY",
+		TokenLimits:          make(map[string]TokenConfig),
+		Algorithm:            FixedWindow,
+		Rules:                make(map[string]TokenConfig),
+		UnknownTokens:        UnknownTokenDefault,
 	}
 }
 
@@ -42,13 +118,32 @@ func NewConfig() *Config {
 func (c *Config) SetTokenLimit(token string, maxRequests int, blockDuration time.Duration) {
 	c.TokenLimits[token] = TokenConfig{
 		MaxRequestsPerSecond: maxRequests,
-		BlockDuration:       blockDuration,
+		BlockDuration:        blockDuration,
+	}
+}
+
+// SetTokenLimitWindow sets the rate limit for a specific token using a
+// window other than the default one
+func (c *Config) SetTokenLimitWindow(token string, maxRequests int, window, blockDuration time.Duration) {
+	c.TokenLimits[token] = TokenConfig{
+		MaxRequestsPerSecond: maxRequests,
+		Window:               window,
+		BlockDuration:        blockDuration,
+	}
+}
+
+// SetRule sets the limit of a named rule
+func (c *Config) SetRule(name string, limit TokenConfig) {
+	if c.Rules == nil {
+		c.Rules = make(map[string]TokenConfig)
 	}
+	c.Rules[name] = limit
 }
 
 // LoadTokenLimitsFromEnv loads token limits from environment variables
-// Format: TOKEN_LIMIT_<TOKEN>=<requests>:<duration>
-// Example: TOKEN_LIMIT_ABC123=100:5m
+// Format: TOKEN_LIMIT_<TOKEN>=<requests>[/<window>][,<requests>/<window>...]:<duration>
+// Example: TOKEN_LIMIT_ABC123=100:5m, TOKEN_LIMIT_ABC123=1000/1m:5m or
+// TOKEN_LIMIT_ABC123=10/1s,500/1m,10000/24h:5m
 func (c *Config) LoadTokenLimitsFromEnv() {
 	for _, env := range os.Environ() {
 		if strings.HasPrefix(env, "TOKEN_LIMIT_") {
@@ -56,24 +151,78 @@ func (c *Config) LoadTokenLimitsFromEnv() {
 			if len(parts) != 2 {
 				continue
 			}
-			
+
 			token := strings.TrimPrefix(parts[0], "TOKEN_LIMIT_")
 			limitParts := strings.Split(parts[1], ":")
 			if len(limitParts) != 2 {
 				continue
 			}
-			
-			requests, err := strconv.Atoi(limitParts[0])
+
+			limits, err := ParseLimits(limitParts[0])
 			if err != nil {
 				continue
 			}
-			
+
 			duration, err := time.ParseDuration(limitParts[1])
 			if err != nil {
 				continue
 			}
-			
-			c.SetTokenLimit(token, requests, duration)
+
+			c.SetTokenLimitWindow(token, limits[0].MaxRequests, limits[0].Window, duration)
+			if len(limits) > 1 {
+				tokenConfig := c.TokenLimits[token]
+				tokenConfig.Limits = limits[1:]
+				c.TokenLimits[token] = tokenConfig
+			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+// ParseLimit parses a limit written as "<requests>" or "<requests>/<window>",
+// such as "10", "1000/1m" or "50000/24h". The window is zero when omitted and
+// at least one millisecond otherwise.
+func ParseLimit(s string) (int, time.Duration, error) {
+	requestsPart, windowPart, hasWindow := strings.Cut(s, "/")
+
+	requests, err := strconv.Atoi(requestsPart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid request count %q: %w", requestsPart, err)
+	}
+
+	if !hasWindow {
+		return requests, 0, nil
+	}
+
+	window, err := time.ParseDuration(windowPart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid window %q: %w", windowPart, err)
+	}
+	// Storage tracks windows in milliseconds
+	if window < time.Millisecond {
+		return 0, 0, fmt.Errorf("window must be at least 1ms, got %v", window)
+	}
+
+	return requests, window, nil
+}
+
+// ParseLimits parses a comma separated list of limits in the format accepted
+// by ParseLimit, such as "10/1s,500/1m,10000/24h"
+func ParseLimits(s string) ([]Limit, error) {
+	var limits []Limit
+	for _, part := range strings.Split(s, ",") {
+		requests, window, err := ParseLimit(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		limits = append(limits, Limit{MaxRequests: requests, Window: window})
+	}
+	return limits, nil
+}
+
+// window returns the configured window, defaulting to one second
+func (c *Config) window() time.Duration {
+	if c.Window > 0 {
+		return c.Window
+	}
+	return time.Second
+}
@@ -2,6 +2,7 @@ package ratelimiter
 
 import (
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -56,11 +57,58 @@ func TestLoadTokenLimitsFromEnv(t *testing.T) {
 		}
 	})
 
+	t.Run("Load token limits with window", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("TOKEN_LIMIT_MINUTE", "1000/1m:5m")
+		os.Setenv("TOKEN_LIMIT_DAILY", "50000/24h:1h")
+		os.Setenv("TOKEN_LIMIT_SECOND", "100:5m")
+
+		config := NewConfig()
+		config.LoadTokenLimitsFromEnv()
+
+		tests := map[string]TokenConfig{
+			"MINUTE": {MaxRequestsPerSecond: 1000, Window: time.Minute, BlockDuration: time.Minute * 5},
+			"DAILY":  {MaxRequestsPerSecond: 50000, Window: time.Hour * 24, BlockDuration: time.Hour},
+			"SECOND": {MaxRequestsPerSecond: 100, BlockDuration: time.Minute * 5},
+		}
+		for token, want := range tests {
+			if limit, exists := config.TokenLimits[token]; !exists {
+				t.Errorf("%s token limit not loaded", token)
+			} else if !reflect.DeepEqual(limit, want) {
+				t.Errorf("%s token limit = %+v, want %+v", token, limit, want)
+			}
+		}
+	})
+
+	t.Run("Load token limits with several quotas", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("TOKEN_LIMIT_TIERED", "10/1s,500/1m,10000/24h:5m")
+
+		config := NewConfig()
+		config.LoadTokenLimitsFromEnv()
+
+		want := TokenConfig{
+			MaxRequestsPerSecond: 10,
+			Window:               time.Second,
+			BlockDuration:        time.Minute * 5,
+			Limits: []Limit{
+				{MaxRequests: 500, Window: time.Minute},
+				{MaxRequests: 10000, Window: time.Hour * 24},
+			},
+		}
+		if limit, exists := config.TokenLimits["TIERED"]; !exists {
+			t.Error("TIERED token limit not loaded")
+		} else if !reflect.DeepEqual(limit, want) {
+			t.Errorf("TIERED token limit = %+v, want %+v", limit, want)
+		}
+	})
+
 	t.Run("Ignore invalid formats", func(t *testing.T) {
 		os.Clearenv()
 		os.Setenv("TOKEN_LIMIT_INVALID1", "100")        // Missing duration
 		os.Setenv("TOKEN_LIMIT_INVALID2", "abc:5m")     // Invalid requests
 		os.Setenv("TOKEN_LIMIT_INVALID3", "100:invalid") // Invalid duration
+		os.Setenv("TOKEN_LIMIT_INVALID4", "100/abc:5m")  // Invalid window
 		os.Setenv("TOKEN_LIMIT_VALID", "200:2m")
 
 		config := NewConfig()
@@ -24,6 +24,64 @@ func TestNewConfig(t *testing.T) {
 	if len(cfg.TokenLimits) != 0 {
 		t.Errorf("TokenLimits length = %v, want %v", len(cfg.TokenLimits), 0)
 	}
+	if cfg.Algorithm != FixedWindow {
+		t.Errorf("Algorithm = %v, want %v", cfg.Algorithm, FixedWindow)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Algorithm
+		wantErr bool
+	}{
+		{input: "fixed_window", want: FixedWindow},
+		{input: "TOKEN_BUCKET", want: TokenBucket},
+		{input: " token_bucket ", want: TokenBucket},
+		{input: "sliding_window_log", want: SlidingWindowLog},
+		{input: "sliding_window_counter", want: SlidingWindowCounter},
+		{input: "", want: FixedWindow},
+		{input: "leaky_bucket", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseAlgorithm(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAlgorithm(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseAlgorithm(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUnknownTokenPolicy(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    UnknownTokenPolicy
+		wantErr bool
+	}{
+		{input: "default", want: UnknownTokenDefault},
+		{input: "REJECT", want: UnknownTokenReject},
+		{input: " ip ", want: UnknownTokenIP},
+		{input: "limit", want: UnknownTokenLimit},
+		{input: "", want: UnknownTokenDefault},
+		{input: "block", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseUnknownTokenPolicy(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseUnknownTokenPolicy(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseUnknownTokenPolicy(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
 }
 
 func TestSetTokenLimit(t *testing.T) {
@@ -93,4 +151,37 @@ func TestSetTokenLimit(t *testing.T) {
 			t.Errorf("BlockDuration = %v, want %v", limit.BlockDuration, time.Minute*2)
 		}
 	})
-}
\ No newline at end of file
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		input        string
+		wantRequests int
+		wantWindow   time.Duration
+		wantErr      bool
+	}{
+		{input: "10", wantRequests: 10},
+		{input: "1000/1m", wantRequests: 1000, wantWindow: time.Minute},
+		{input: "50000/24h", wantRequests: 50000, wantWindow: time.Hour * 24},
+		{input: "abc/1m", wantErr: true},
+		{input: "10/abc", wantErr: true},
+		{input: "10/0s", wantErr: true},
+		{input: "10/500us", wantErr: true},
+		{input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			requests, window, err := ParseLimit(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLimit(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("ParseLimit(%q) requests = %v, want %v", tt.input, requests, tt.wantRequests)
+			}
+			if window != tt.wantWindow {
+				t.Errorf("ParseLimit(%q) window = %v, want %v", tt.input, window, tt.wantWindow)
+			}
+		})
+	}
+}
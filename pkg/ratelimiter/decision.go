@@ -0,0 +1,116 @@
+package ratelimiter
+
+import "time"
+
+// Request describes a single rate limit check
+type Request struct {
+	// Key identifies the client, either an IP address or a token
+	Key string
+
+	// IsToken reports whether Key is an API token
+	IsToken bool
+
+	// Rule selects a named limit from Config.Rules. When empty, the IP or
+	// token limits apply.
+	Rule string
+
+	// Cost is the number of units the request consumes from its limits, so
+	// that expensive requests such as searches or exports count for more
+	// than one. Zero means one. Requests whose cost doesn't fit are denied
+	// without being counted.
+	Cost int
+}
+
+// cost returns the number of units the request consumes
+func (r Request) cost() int64 {
+	if r.Cost <= 0 {
+		return 1
+	}
+	return int64(r.Cost)
+}
+
+// Reason explains a Decision
+type Reason string
+
+const (
+	// ReasonAllowed means the request fits within every limit
+	ReasonAllowed Reason = "allowed"
+
+	// ReasonLimitExceeded means the request exceeded a limit. The key may
+	// have been blocked as a result.
+	ReasonLimitExceeded Reason = "limit_exceeded"
+
+	// ReasonBlocked means the key was already blocked by an earlier request
+	// and the request was not counted
+	ReasonBlocked Reason = "blocked"
+)
+
+// Rules that can apply to a request
+const (
+	// RuleDefault is the configuration shared by IPs and tokens without
+	// limits of their own
+	RuleDefault = "default"
+
+	// RuleToken is the configuration of a token listed in Config.TokenLimits
+	RuleToken = "token"
+
+	// RuleUnknownToken is Config.UnknownTokenLimit, applied to requests with
+	// tokens not listed in Config.TokenLimits
+	RuleUnknownToken = "unknown_token"
+)
+
+// Key types of a Decision
+const (
+	// KeyTypeIP is a client IP address or another key that is not a token
+	KeyTypeIP = "ip"
+
+	// KeyTypeToken is an API token
+	KeyTypeToken = "token"
+)
+
+// Decision is the outcome of a rate limit check
+type Decision struct {
+	// Allowed reports whether the request may proceed
+	Allowed bool
+
+	// Reason explains why the request was allowed or denied
+	Reason Reason
+
+	// Key is the storage key the request was counted against, which is
+	// hashed when Config.KeyHasher is set
+	Key string
+
+	// KeyType is KeyTypeToken for requests limited by token and KeyTypeIP
+	// otherwise
+	KeyType string
+
+	// Rule names the configuration that applied to the request: RuleDefault,
+	// RuleToken or the name of a rule from Config.Rules
+	Rule string
+
+	// Limit is the limit that denied the request. For allowed requests it is
+	// the key's primary limit, or its most constrained one when it has
+	// several.
+	Limit Limit
+
+	// Remaining is the number of requests Limit still allows
+	Remaining int
+
+	// Count is the number of requests counted in the key's fixed window,
+	// including this one. It is zero when the storage doesn't report it,
+	// which is the case for the other algorithms.
+	Count int
+
+	// ResetAt is when Limit resets: the end of the current window, or when
+	// the token bucket is full again
+	ResetAt time.Time
+
+	// BlockedUntil is when the key's block expires. It is zero when the key
+	// is not blocked.
+	BlockedUntil time.Time
+
+	// RetryAfter is how long a denied client should wait before trying
+	// again: until its block expires, or else until Limit resets. It is zero
+	// for allowed requests.
+	RetryAfter time.Duration
+}
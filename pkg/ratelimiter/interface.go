@@ -6,4 +6,8 @@ import "context"
 type RateLimiterInterface interface {
 	IsAllowed(ctx context.Context, key string, isToken bool) (bool, error)
 	GetRemainingRequests(ctx context.Context, key string, isToken bool) (int, error)
-}
\ No newline at end of file
+
+	// Allow checks a request and describes the outcome, including the
+	// information needed for rate limit response headers
+	Allow(ctx context.Context, req Request) (Decision, error)
+}
@@ -0,0 +1,93 @@
+package ratelimiter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Namespaces keep IP and token keys apart in storage, so that a token that
+// happens to look like an IP address never shares its counter
+const (
+	namespaceIP    = KeyTypeIP
+	namespaceToken = KeyTypeToken
+)
+
+// KeyHasher derives the storage key of a client key, so that API tokens and
+// client IPs are not written to storage in clear text
+type KeyHasher interface {
+	// HashKey returns the hash of a namespaced key, such as "token:abc123"
+	HashKey(key string) string
+}
+
+// HMACKeyHasher hashes keys with HMAC-SHA256. Without the secret, the hashes
+// can't be reversed by trying every IP address or likely token.
+type HMACKeyHasher struct {
+	secret []byte
+}
+
+// NewHMACKeyHasher creates a KeyHasher that hashes keys with HMAC-SHA256 and
+// secret. Changing the secret resets every counter and block.
+func NewHMACKeyHasher(secret []byte) *HMACKeyHasher {
+	return &HMACKeyHasher{secret: secret}
+}
+
+// HashKey returns the hex encoded HMAC-SHA256 of key
+func (h *HMACKeyHasher) HashKey(key string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// namespace returns the storage namespace of the request's key
+func (r Request) namespace() string {
+	if r.IsToken {
+		return namespaceToken
+	}
+	return namespaceIP
+}
+
+// storageKey returns the key requests are counted against, such as
+// "token:abc123" or, for requests that select a rule, "ip/checkout:10.0.0.1".
+// With a hasher, the client key is replaced by its hash.
+//
+// The namespace comes first and the rule is escaped, so that no rule name
+// and client key can produce the key of another namespace or rule.
+func (r Request) storageKey(hasher KeyHasher) string {
+	key := r.Key
+	if hasher != nil {
+		key = hasher.HashKey(r.namespace() + ":" + r.Key)
+	}
+	if r.Rule != "" {
+		return r.namespace() + "/" + ruleEscaper.Replace(r.Rule) + ":" + key
+	}
+	return r.namespace() + ":" + key
+}
+
+// ruleEscaper escapes the separator between the rule and the client key
+var ruleEscaper = strings.NewReplacer("%", "%25", ":", "%3A")
+
+// ruleUnescaper reverses ruleEscaper
+var ruleUnescaper = strings.NewReplacer("%25", "%", "%3A", ":")
+
+// parseStorageKey returns the request a storage key was built from by
+// storageKey, or false for keys of another format. For hashed keys, the Key
+// of the request is the hash of the client key.
+func parseStorageKey(key string) (Request, bool) {
+	prefix, client, ok := strings.Cut(key, ":")
+	if !ok {
+		return Request{}, false
+	}
+	namespace, rule, _ := strings.Cut(prefix, "/")
+
+	req := Request{Key: client, Rule: ruleUnescaper.Replace(rule)}
+	switch namespace {
+	case namespaceIP:
+	case namespaceToken:
+		req.IsToken = true
+	default:
+		return Request{}, false
+	}
+	return req, true
+}
@@ -0,0 +1,80 @@
+package ratelimiter
+
+import "testing"
+
+func TestHMACKeyHasher(t *testing.T) {
+	hasher := NewHMACKeyHasher([]byte("secret"))
+
+	got := hasher.HashKey("token:abc123")
+	if len(got) != 64 {
+		t.Fatalf("HashKey() = %q, want 64 hex characters", got)
+	}
+	if got != hasher.HashKey("token:abc123") {
+		t.Error("HashKey() is not deterministic")
+	}
+	if got == NewHMACKeyHasher([]byte("other")).HashKey("token:abc123") {
+		t.Error("HashKey() does not depend on the secret")
+	}
+}
+
+func TestStorageKey(t *testing.T) {
+	tests := []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{name: "IP", req: Request{Key: "10.0.0.1"}, want: "ip:10.0.0.1"},
+		{name: "Token", req: Request{Key: "10.0.0.1", IsToken: true}, want: "token:10.0.0.1"},
+		{name: "Rule", req: Request{Key: "10.0.0.1", Rule: "checkout"}, want: "ip/checkout:10.0.0.1"},
+		{name: "Rule with separator", req: Request{Key: "b", Rule: "a:%"}, want: "ip/a%3A%25:b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.req.storageKey(nil); got != tt.want {
+				t.Errorf("storageKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStorageKey(t *testing.T) {
+	for _, req := range []Request{
+		{Key: "10.0.0.1"},
+		{Key: "abc", IsToken: true},
+		{Key: "2001:db8::/48", Rule: "ipv6/48"},
+		{Key: "b:c", Rule: "a:%3A"},
+	} {
+		got, ok := parseStorageKey(req.storageKey(nil))
+		if !ok || got != req {
+			t.Errorf("parseStorageKey(%q) = %+v, %v, want %+v", req.storageKey(nil), got, ok, req)
+		}
+	}
+
+	for _, key := range []string{"10.0.0.1", "user:abc", "ipv6/48:2001:db8::/48"} {
+		if _, ok := parseStorageKey(key); ok {
+			t.Errorf("parseStorageKey(%q) should fail", key)
+		}
+	}
+}
+
+func TestStorageKeysDontCollide(t *testing.T) {
+	requests := []Request{
+		{Key: "ip:10.0.0.1", IsToken: true},
+		{Key: "10.0.0.1", Rule: "token"},
+		{Key: "ip:10.0.0.1", Rule: "token"},
+		{Key: "b:c", Rule: "a"},
+		{Key: "c", Rule: "a:b"},
+		{Key: "2001:db8::/64"},
+		{Key: "db8::/64", Rule: "2001"},
+	}
+
+	seen := make(map[string]Request)
+	for _, req := range requests {
+		key := req.storageKey(nil)
+		if other, ok := seen[key]; ok {
+			t.Errorf("%+v and %+v share the storage key %q", req, other, key)
+		}
+		seen[key] = req
+	}
+}
@@ -2,80 +2,361 @@ package ratelimiter
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
 	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ErrUnknownRule is returned for requests that select a rule missing from
+// Config.Rules
+var ErrUnknownRule = errors.New("unknown rate limit rule")
+
 // RateLimiter handles the rate limiting logic
 type RateLimiter struct {
 	storage storage.Storage
 	config  *Config
+
+	// tracer creates the spans of Allow, when set
+	tracer trace.Tracer
+
+	// logger logs blocks, sampled denials and errors, when set
+	logger          *slog.Logger
+	denyLogSampling int
+	denials         atomic.Uint64
+
+	// listeners are notified of every decision
+	listeners []Listener
 }
 
+// Option configures optional RateLimiter behavior
+type Option func(*RateLimiter)
+
 // New creates a new RateLimiter instance
-func New(storage storage.Storage, config *Config) *RateLimiter {
-	return &RateLimiter{
+func New(storage storage.Storage, config *Config, opts ...Option) *RateLimiter {
+	r := &RateLimiter{
 		storage: storage,
 		config:  config,
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 // IsAllowed checks if a request should be allowed based on the key (IP or token)
 func (r *RateLimiter) IsAllowed(ctx context.Context, key string, isToken bool) (bool, error) {
+	decision, err := r.Allow(ctx, Request{Key: key, IsToken: isToken})
+	if err != nil {
+		return false, err
+	}
+	return decision.Allowed, nil
+}
+
+// Allow checks if a request should be allowed and describes the outcome
+func (r *RateLimiter) Allow(ctx context.Context, req Request) (Decision, error) {
+	ctx, span := r.startSpan(ctx, req)
+	defer span.End()
+
+	decision, err := r.allow(ctx, req)
+	endSpan(span, decision, err)
+	r.log(ctx, req, decision, err)
+	r.notify(ctx, req, decision, err)
+	return decision, err
+}
+
+// allow checks a request within the span of Allow
+func (r *RateLimiter) allow(ctx context.Context, req Request) (Decision, error) {
+	// Get the appropriate limits for the key
+	limit, rule, err := r.limitForRequest(req)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	key := req.storageKey(r.config.KeyHasher)
+	cost := min(req.cost(), limit.maxCost())
+	decision, err := r.check(ctx, key, cost, limit)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision.Key = key
+	decision.KeyType = req.namespace()
+	decision.Rule = rule
+	if !decision.Allowed {
+		// Waiting for the window is pointless while the key is blocked
+		retryAt := decision.ResetAt
+		if decision.BlockedUntil.After(retryAt) {
+			retryAt = decision.BlockedUntil
+		}
+		decision.RetryAfter = max(0, time.Until(retryAt))
+	}
+
+	return decision, nil
+}
+
+// check consumes cost units for key from limit
+func (r *RateLimiter) check(ctx context.Context, key string, cost int64, limit TokenConfig) (Decision, error) {
+	// A single fixed window is checked, counted and blocked in one atomic call
+	if limit.isFixedWindow() {
+		result, err := r.storage.CheckAndIncrement(ctx, key, cost,
+			int64(limit.MaxRequestsPerSecond), limit.Window, limit.BlockDuration)
+		if err != nil {
+			return Decision{}, fmt.Errorf("failed to check request count: %w", err)
+		}
+		return newDecision(limit.primary(), result), nil
+	}
+
 	// First check if the key is blocked
 	blocked, err := r.storage.IsBlocked(ctx, key)
 	if err != nil {
-		return false, fmt.Errorf("failed to check if key is blocked: %w", err)
+		return Decision{}, fmt.Errorf("failed to check if key is blocked: %w", err)
 	}
 	if blocked {
-		return false, nil
+		return r.blockedDecision(ctx, key, limit)
 	}
 
-	// Get the appropriate limits for the key
-	maxRequests := r.config.MaxRequestsPerSecond
-	blockDuration := r.config.BlockDuration
+	decision, err := r.consume(ctx, key, cost, limit)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	// If we've exceeded the limit, block the key
+	if !decision.Allowed && limit.BlockDuration > 0 {
+		err = r.storage.Block(ctx, key, limit.BlockDuration)
+		if err != nil {
+			return Decision{}, fmt.Errorf("failed to block key: %w", err)
+		}
+		decision.BlockedUntil = time.Now().Add(limit.BlockDuration)
+	}
+
+	return decision, nil
+}
+
+// blockedDecision describes a request denied because key is already blocked
+func (r *RateLimiter) blockedDecision(ctx context.Context, key string, limit TokenConfig) (Decision, error) {
+	blockTTL, err := r.storage.GetBlockTTL(ctx, key)
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to get block expiration: %w", err)
+	}
+	windowTTL, err := r.storage.GetWindowTTL(ctx, key)
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to get window expiration: %w", err)
+	}
+
+	now := time.Now()
+	decision := Decision{
+		Allowed: false,
+		Reason:  ReasonBlocked,
+		Limit:   limit.primary(),
+		ResetAt: now.Add(windowTTL),
+	}
+	if blockTTL > 0 {
+		decision.BlockedUntil = now.Add(blockTTL)
+	}
+	return decision, nil
+}
+
+// limitForRequest returns the limits that apply to a request and the name of
+// the rule they come from
+func (r *RateLimiter) limitForRequest(req Request) (TokenConfig, string, error) {
+	if req.Rule == "" {
+		limit, rule := r.limitFor(req.Key, req.IsToken)
+		return limit, rule, nil
+	}
+
+	ruleConfig, exists := r.config.Rules[req.Rule]
+	if !exists && req.Rule == RuleUnknownToken {
+		ruleConfig, exists = r.config.UnknownTokenLimit, true
+	}
+	if !exists {
+		return TokenConfig{}, "", fmt.Errorf("%w %q", ErrUnknownRule, req.Rule)
+	}
+	return r.withDefaults(ruleConfig), req.Rule, nil
+}
+
+// HasRule reports whether requests can select the rule name
+func (r *RateLimiter) HasRule(name string) bool {
+	_, exists := r.config.Rules[name]
+	return exists || name == RuleUnknownToken
+}
 
-	// If it's a token and we have specific limits for it, use those instead
+// limitFor returns the limits that apply to a key and the name of the rule
+// they come from. Tokens with specific limits use those instead of the
+// defaults.
+func (r *RateLimiter) limitFor(key string, isToken bool) (TokenConfig, string) {
 	if isToken {
 		if tokenConfig, exists := r.config.TokenLimits[key]; exists {
-			maxRequests = tokenConfig.MaxRequestsPerSecond
-			blockDuration = tokenConfig.BlockDuration
+			return r.withDefaults(tokenConfig), RuleToken
 		}
 	}
+	return r.withDefaults(TokenConfig{
+		MaxRequestsPerSecond: r.config.MaxRequestsPerSecond,
+		BlockDuration:        r.config.BlockDuration,
+		Burst:                r.config.Burst,
+		Limits:               r.config.Limits,
+	}), RuleDefault
+}
 
-	// Increment the request count
-	count, err := r.storage.IncrementRequestCount(ctx, key, time.Second)
-	if err != nil {
-		return false, fmt.Errorf("failed to increment request count: %w", err)
+// withDefaults fills in the default window and algorithm of a limit that
+// does not set its own
+func (r *RateLimiter) withDefaults(limit TokenConfig) TokenConfig {
+	if limit.Window <= 0 {
+		limit.Window = r.config.window()
+	}
+	if limit.Algorithm == "" {
+		limit.Algorithm = r.config.Algorithm
 	}
 
-	// If we've exceeded the limit, block the key
-	if count > int64(maxRequests) {
-		err = r.storage.Block(ctx, key, blockDuration)
+	// Additional limits without a window use the default one
+	if len(limit.Limits) > 0 {
+		limits := make([]Limit, len(limit.Limits))
+		for i, l := range limit.Limits {
+			if l.Window <= 0 {
+				l.Window = r.config.window()
+			}
+			limits[i] = l
+		}
+		limit.Limits = limits
+	}
+
+	return limit
+}
+
+// primary returns the limit described by MaxRequestsPerSecond and Window
+func (c TokenConfig) primary() Limit {
+	return Limit{MaxRequests: c.MaxRequestsPerSecond, Window: c.Window}
+}
+
+// limits returns the primary limit followed by the additional ones
+func (c TokenConfig) limits() []Limit {
+	return append([]Limit{c.primary()}, c.Limits...)
+}
+
+// storageQuotas converts limits into the quotas storage counts them with
+func storageQuotas(limits []Limit) []storage.Quota {
+	quotas := make([]storage.Quota, len(limits))
+	for i, l := range limits {
+		quotas[i] = storage.Quota{Max: int64(l.MaxRequests), Window: l.Window}
+	}
+	return quotas
+}
+
+// maxCost returns the largest cost worth counting, one unit more than the
+// largest limit. Larger costs are denied just the same, so capping them
+// keeps counters from overflowing.
+func (c TokenConfig) maxCost() int64 {
+	largest := max(c.MaxRequestsPerSecond, c.Burst)
+	for _, l := range c.Limits {
+		largest = max(largest, l.MaxRequests)
+	}
+	return int64(largest) + 1
+}
+
+// isFixedWindow reports whether the configuration is a single fixed window
+func (c TokenConfig) isFixedWindow() bool {
+	return len(c.Limits) == 0 && (c.Algorithm == FixedWindow || c.Algorithm == "")
+}
+
+// consume records cost units for key using the limit's algorithm and
+// reports whether they fit within the limit
+func (r *RateLimiter) consume(ctx context.Context, key string, cost int64, limit TokenConfig) (Decision, error) {
+	if len(limit.Limits) > 0 {
+		return r.consumeQuotas(ctx, key, cost, limit)
+	}
+
+	maxRequests := int64(limit.MaxRequestsPerSecond)
+
+	var result storage.Result
+	var err error
+	switch limit.Algorithm {
+	case TokenBucket:
+		capacity := int64(limit.Burst)
+		if capacity <= 0 {
+			capacity = maxRequests
+		}
+
+		// Refill the whole limit over one window
+		rate := float64(maxRequests) / limit.Window.Seconds()
+		result, err = r.storage.TakeToken(ctx, key, cost, rate, capacity)
 		if err != nil {
-			return false, fmt.Errorf("failed to block key: %w", err)
+			return Decision{}, fmt.Errorf("failed to take token: %w", err)
 		}
-		return false, nil
+
+	case SlidingWindowLog:
+		result, err = r.storage.SlidingWindowLog(ctx, key, cost, maxRequests, limit.Window)
+		if err != nil {
+			return Decision{}, fmt.Errorf("failed to record request in sliding log: %w", err)
+		}
+
+	case SlidingWindowCounter:
+		result, err = r.storage.SlidingWindowCounter(ctx, key, cost, maxRequests, limit.Window)
+		if err != nil {
+			return Decision{}, fmt.Errorf("failed to increment sliding window counter: %w", err)
+		}
+
+	default:
+		return Decision{}, fmt.Errorf("unsupported rate limiting algorithm %q", limit.Algorithm)
+	}
+
+	return newDecision(limit.primary(), result), nil
+}
+
+// consumeQuotas checks the primary limit and every additional limit at once
+func (r *RateLimiter) consumeQuotas(ctx context.Context, key string, cost int64, limit TokenConfig) (Decision, error) {
+	limits := limit.limits()
+	result, err := r.storage.IncrementQuotas(ctx, key, cost, storageQuotas(limits))
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to increment request counts: %w", err)
+	}
+
+	// Report the limit that denied the request, or the most constrained one
+	matched := limits[0]
+	if result.Quota >= 0 && result.Quota < len(limits) {
+		matched = limits[result.Quota]
 	}
 
-	return true, nil
+	return newDecision(matched, result), nil
+}
+
+// newDecision builds the decision for a limit from a storage result
+func newDecision(limit Limit, result storage.Result) Decision {
+	now := time.Now()
+	decision := Decision{
+		Allowed:   result.Allowed,
+		Reason:    ReasonAllowed,
+		Limit:     limit,
+		Remaining: int(result.Remaining),
+		Count:     int(result.Count),
+		ResetAt:   now.Add(result.ResetAfter),
+	}
+	if result.BlockTTL > 0 {
+		decision.BlockedUntil = now.Add(result.BlockTTL)
+	}
+
+	switch {
+	case result.WasBlocked:
+		decision.Reason = ReasonBlocked
+	case !result.Allowed:
+		decision.Reason = ReasonLimitExceeded
+	}
+	return decision
 }
 
 // GetRemainingRequests returns the number of remaining requests allowed for a key
 func (r *RateLimiter) GetRemainingRequests(ctx context.Context, key string, isToken bool) (int, error) {
-	count, err := r.storage.GetRequestCount(ctx, key)
+	storageKey := Request{Key: key, IsToken: isToken}.storageKey(r.config.KeyHasher)
+	count, err := r.storage.GetRequestCount(ctx, storageKey)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get request count: %w", err)
 	}
 
-	maxRequests := r.config.MaxRequestsPerSecond
-	if isToken {
-		if tokenConfig, exists := r.config.TokenLimits[key]; exists {
-			maxRequests = tokenConfig.MaxRequestsPerSecond
-		}
-	}
+	limit, _ := r.limitFor(key, isToken)
+	maxRequests := limit.MaxRequestsPerSecond
 
 	remaining := maxRequests - int(count)
 	if remaining < 0 {
@@ -2,9 +2,11 @@ package ratelimiter
 
 import (
         "context"
+        "math"
         "testing"
         "time"
 
+        "github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
         "github.com/stretchr/testify/mock"
         "github.com/stretchr/testify/suite"
 )
@@ -24,6 +26,36 @@ func (m *MockStorage) IncrementRequestCount(ctx context.Context, key string, exp
         return args.Get(0).(int64), args.Error(1)
 }
 
+func (m *MockStorage) IncrByWithCost(ctx context.Context, key string, cost int64, expiration time.Duration) (int64, error) {
+        args := m.Called(ctx, key, cost, expiration)
+        return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockStorage) CheckAndIncrement(ctx context.Context, key string, cost, limit int64, window, blockDuration time.Duration) (storage.Result, error) {
+        args := m.Called(ctx, key, cost, limit, window, blockDuration)
+        return args.Get(0).(storage.Result), args.Error(1)
+}
+
+func (m *MockStorage) TakeToken(ctx context.Context, key string, cost int64, rate float64, capacity int64) (storage.Result, error) {
+        args := m.Called(ctx, key, cost, rate, capacity)
+        return args.Get(0).(storage.Result), args.Error(1)
+}
+
+func (m *MockStorage) SlidingWindowLog(ctx context.Context, key string, cost, limit int64, window time.Duration) (storage.Result, error) {
+        args := m.Called(ctx, key, cost, limit, window)
+        return args.Get(0).(storage.Result), args.Error(1)
+}
+
+func (m *MockStorage) SlidingWindowCounter(ctx context.Context, key string, cost, limit int64, window time.Duration) (storage.Result, error) {
+        args := m.Called(ctx, key, cost, limit, window)
+        return args.Get(0).(storage.Result), args.Error(1)
+}
+
+func (m *MockStorage) IncrementQuotas(ctx context.Context, key string, cost int64, quotas []storage.Quota) (storage.Result, error) {
+        args := m.Called(ctx, key, cost, quotas)
+        return args.Get(0).(storage.Result), args.Error(1)
+}
+
 func (m *MockStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
         args := m.Called(ctx, key)
         return args.Bool(0), args.Error(1)
@@ -34,6 +66,32 @@ func (m *MockStorage) Block(ctx context.Context, key string, duration time.Durat
         return args.Error(0)
 }
 
+func (m *MockStorage) GetBlockTTL(ctx context.Context, key string) (time.Duration, error) {
+        args := m.Called(ctx, key)
+        return args.Get(0).(time.Duration), args.Error(1)
+}
+
+func (m *MockStorage) GetWindowTTL(ctx context.Context, key string) (time.Duration, error) {
+        args := m.Called(ctx, key)
+        return args.Get(0).(time.Duration), args.Error(1)
+}
+
+func (m *MockStorage) Unblock(ctx context.Context, key string) error {
+        args := m.Called(ctx, key)
+        return args.Error(0)
+}
+
+func (m *MockStorage) Reset(ctx context.Context, key string, quotas []storage.Quota) error {
+        args := m.Called(ctx, key, quotas)
+        return args.Error(0)
+}
+
+func (m *MockStorage) ListBlocked(ctx context.Context, limit int) ([]storage.BlockedKey, error) {
+        args := m.Called(ctx, limit)
+        blocked, _ := args.Get(0).([]storage.BlockedKey)
+        return blocked, args.Error(1)
+}
+
 func (m *MockStorage) Close() error {
         args := m.Called()
         return args.Error(0)
@@ -61,8 +119,7 @@ func (s *RateLimiterTestSuite) TestIPUnderLimit() {
         limiter := New(s.mockStorage, config)
         key := "192.168.1.1"
 
-        s.mockStorage.On("IsBlocked", s.ctx, key).Return(false, nil)
-        s.mockStorage.On("IncrementRequestCount", s.ctx, key, time.Second).Return(int64(4), nil)
+        s.mockStorage.On("CheckAndIncrement", s.ctx, "ip:"+key, int64(1), int64(5), time.Second, time.Minute).Return(storage.Result{Allowed: true, Remaining: 1}, nil)
 
         allowed, err := limiter.IsAllowed(s.ctx, key, false)
         s.NoError(err)
@@ -79,9 +136,7 @@ func (s *RateLimiterTestSuite) TestIPOverLimit() {
         limiter := New(s.mockStorage, config)
         key := "192.168.1.2"
 
-        s.mockStorage.On("IsBlocked", s.ctx, key).Return(false, nil)
-        s.mockStorage.On("IncrementRequestCount", s.ctx, key, time.Second).Return(int64(6), nil)
-        s.mockStorage.On("Block", s.ctx, key, time.Minute).Return(nil)
+        s.mockStorage.On("CheckAndIncrement", s.ctx, "ip:"+key, int64(1), int64(5), time.Second, time.Minute).Return(storage.Result{Allowed: false, Blocked: true}, nil)
 
         allowed, err := limiter.IsAllowed(s.ctx, key, false)
         s.NoError(err)
@@ -104,8 +159,7 @@ func (s *RateLimiterTestSuite) TestTokenUnderCustomLimit() {
         limiter := New(s.mockStorage, config)
         key := "test-token"
 
-        s.mockStorage.On("IsBlocked", s.ctx, key).Return(false, nil)
-        s.mockStorage.On("IncrementRequestCount", s.ctx, key, time.Second).Return(int64(9), nil)
+        s.mockStorage.On("CheckAndIncrement", s.ctx, "token:"+key, int64(1), int64(10), time.Second, time.Minute).Return(storage.Result{Allowed: true, Remaining: 1}, nil)
 
         allowed, err := limiter.IsAllowed(s.ctx, key, true)
         s.NoError(err)
@@ -128,9 +182,7 @@ func (s *RateLimiterTestSuite) TestTokenOverCustomLimit() {
         limiter := New(s.mockStorage, config)
         key := "test-token"
 
-        s.mockStorage.On("IsBlocked", s.ctx, key).Return(false, nil)
-        s.mockStorage.On("IncrementRequestCount", s.ctx, key, time.Second).Return(int64(11), nil)
-        s.mockStorage.On("Block", s.ctx, key, time.Minute).Return(nil)
+        s.mockStorage.On("CheckAndIncrement", s.ctx, "token:"+key, int64(1), int64(10), time.Second, time.Minute).Return(storage.Result{Allowed: false, Blocked: true}, nil)
 
         allowed, err := limiter.IsAllowed(s.ctx, key, true)
         s.NoError(err)
@@ -138,6 +190,433 @@ func (s *RateLimiterTestSuite) TestTokenOverCustomLimit() {
         s.mockStorage.AssertExpectations(s.T())
 }
 
+// TestBlockedKeyIsDenied tests that a key under an active block is denied
+func (s *RateLimiterTestSuite) TestBlockedKeyIsDenied() {
+        config := &Config{
+                MaxRequestsPerSecond: 5,
+                BlockDuration:        time.Minute,
+        }
+        limiter := New(s.mockStorage, config)
+        key := "192.168.1.9"
+
+        s.mockStorage.On("CheckAndIncrement", s.ctx, "ip:"+key, int64(1), int64(5), time.Second, time.Minute).Return(storage.Result{Allowed: false, Blocked: true, WasBlocked: true, BlockTTL: 30 * time.Second}, nil)
+
+        decision, err := limiter.Allow(s.ctx, Request{Key: key})
+        s.NoError(err)
+        s.False(decision.Allowed)
+        s.Equal(ReasonBlocked, decision.Reason)
+        s.InDelta(30*time.Second, decision.RetryAfter, float64(time.Second))
+        s.mockStorage.AssertNotCalled(s.T(), "Block", mock.Anything, mock.Anything, mock.Anything)
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestDecisionLimitExceeded tests the decision for the request that exceeds the limit
+func (s *RateLimiterTestSuite) TestDecisionLimitExceeded() {
+        config := &Config{
+                MaxRequestsPerSecond: 5,
+                BlockDuration:        time.Minute,
+        }
+        limiter := New(s.mockStorage, config)
+        key := "192.168.1.10"
+
+        s.mockStorage.On("CheckAndIncrement", s.ctx, "ip:"+key, int64(1), int64(5), time.Second, time.Minute).Return(storage.Result{
+                Allowed:    false,
+                Blocked:    true,
+                ResetAfter: 500 * time.Millisecond,
+                BlockTTL:   time.Minute,
+        }, nil)
+
+        decision, err := limiter.Allow(s.ctx, Request{Key: key})
+        s.NoError(err)
+        s.False(decision.Allowed)
+        s.Equal(ReasonLimitExceeded, decision.Reason)
+        s.Equal("ip:"+key, decision.Key)
+        s.Equal(KeyTypeIP, decision.KeyType)
+        s.Equal(RuleDefault, decision.Rule)
+        s.Equal(Limit{MaxRequests: 5, Window: time.Second}, decision.Limit)
+        s.WithinDuration(time.Now().Add(time.Minute), decision.BlockedUntil, time.Second)
+
+        // The client has to wait for the block, not only for the window
+        s.InDelta(time.Minute, decision.RetryAfter, float64(time.Second))
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestDecisionAllowed tests the decision for an allowed token request
+func (s *RateLimiterTestSuite) TestDecisionAllowed() {
+        config := &Config{
+                MaxRequestsPerSecond: 5,
+                BlockDuration:        time.Minute,
+                TokenLimits: map[string]TokenConfig{
+                        "test-token": {
+                                MaxRequestsPerSecond: 10,
+                                BlockDuration:        time.Minute,
+                        },
+                },
+        }
+        limiter := New(s.mockStorage, config)
+        key := "test-token"
+
+        s.mockStorage.On("CheckAndIncrement", s.ctx, "token:"+key, int64(1), int64(10), time.Second, time.Minute).Return(storage.Result{Allowed: true, Remaining: 9, ResetAfter: time.Second}, nil)
+
+        decision, err := limiter.Allow(s.ctx, Request{Key: key, IsToken: true})
+        s.NoError(err)
+        s.True(decision.Allowed)
+        s.Equal(ReasonAllowed, decision.Reason)
+        s.Equal(KeyTypeToken, decision.KeyType)
+        s.Equal(RuleToken, decision.Rule)
+        s.Equal(9, decision.Remaining)
+        s.Zero(decision.RetryAfter)
+        s.True(decision.BlockedUntil.IsZero())
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestDecisionBlockedKeyReadsTTLs tests that a blocked key reports when its block expires
+func (s *RateLimiterTestSuite) TestDecisionBlockedKeyReadsTTLs() {
+        config := &Config{
+                MaxRequestsPerSecond: 5,
+                BlockDuration:        time.Minute,
+                Algorithm:            SlidingWindowLog,
+        }
+        limiter := New(s.mockStorage, config)
+        key := "192.168.1.11"
+
+        s.mockStorage.On("IsBlocked", s.ctx, "ip:"+key).Return(true, nil)
+        s.mockStorage.On("GetBlockTTL", s.ctx, "ip:"+key).Return(45*time.Second, nil)
+        s.mockStorage.On("GetWindowTTL", s.ctx, "ip:"+key).Return(time.Duration(0), nil)
+
+        decision, err := limiter.Allow(s.ctx, Request{Key: key})
+        s.NoError(err)
+        s.False(decision.Allowed)
+        s.Equal(ReasonBlocked, decision.Reason)
+        s.WithinDuration(time.Now().Add(45*time.Second), decision.BlockedUntil, time.Second)
+        s.InDelta(45*time.Second, decision.RetryAfter, float64(time.Second))
+        s.mockStorage.AssertNotCalled(s.T(), "SlidingWindowLog", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestRuleLimit tests that requests selecting a rule use its limit and counter
+func (s *RateLimiterTestSuite) TestRuleLimit() {
+        config := NewConfig()
+        config.MaxRequestsPerSecond = 5
+        config.BlockDuration = time.Minute
+        config.SetRule("ipv4/24", TokenConfig{
+                MaxRequestsPerSecond: 50,
+                BlockDuration:        time.Hour,
+        })
+        limiter := New(s.mockStorage, config)
+
+        s.mockStorage.On("CheckAndIncrement", s.ctx, "ip/ipv4/24:10.0.0.0/24", int64(1), int64(50), time.Second, time.Hour).Return(storage.Result{Allowed: true, Remaining: 49}, nil)
+
+        decision, err := limiter.Allow(s.ctx, Request{Key: "10.0.0.0/24", Rule: "ipv4/24"})
+        s.NoError(err)
+        s.True(decision.Allowed)
+        s.Equal("ipv4/24", decision.Rule)
+        s.Equal("ip/ipv4/24:10.0.0.0/24", decision.Key)
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestUnknownRule tests that selecting a rule that does not exist fails
+func (s *RateLimiterTestSuite) TestUnknownRule() {
+        limiter := New(s.mockStorage, NewConfig())
+
+        _, err := limiter.Allow(s.ctx, Request{Key: "10.0.0.1", Rule: "missing"})
+        s.ErrorIs(err, ErrUnknownRule)
+        s.mockStorage.AssertNotCalled(s.T(), "CheckAndIncrement", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// TestUnknownTokenRule tests that the unknown token rule uses Config.UnknownTokenLimit
+func (s *RateLimiterTestSuite) TestUnknownTokenRule() {
+        config := NewConfig()
+        config.UnknownTokenLimit = TokenConfig{
+                MaxRequestsPerSecond: 2,
+                BlockDuration:        time.Hour,
+        }
+        limiter := New(s.mockStorage, config)
+
+        s.mockStorage.On("CheckAndIncrement", s.ctx, "ip/unknown_token:10.0.0.1", int64(1), int64(2), time.Second, time.Hour).Return(storage.Result{Allowed: true, Remaining: 1}, nil)
+
+        decision, err := limiter.Allow(s.ctx, Request{Key: "10.0.0.1", Rule: RuleUnknownToken})
+        s.NoError(err)
+        s.True(decision.Allowed)
+        s.Equal(RuleUnknownToken, decision.Rule)
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestKeyHasher tests that storage keys hash the client key, keeping IPs and tokens apart
+func (s *RateLimiterTestSuite) TestKeyHasher() {
+        config := NewConfig()
+        config.KeyHasher = NewHMACKeyHasher([]byte("secret"))
+        limiter := New(s.mockStorage, config)
+
+        ipKey := "ip:" + config.KeyHasher.HashKey("ip:abc")
+        tokenKey := "token:" + config.KeyHasher.HashKey("token:abc")
+        s.NotEqual(ipKey[len("ip:"):], tokenKey[len("token:"):])
+
+        s.mockStorage.On("CheckAndIncrement", s.ctx, ipKey, int64(1), int64(10), time.Second, 5*time.Minute).Return(storage.Result{Allowed: true}, nil)
+        s.mockStorage.On("CheckAndIncrement", s.ctx, tokenKey, int64(1), int64(10), time.Second, 5*time.Minute).Return(storage.Result{Allowed: true}, nil)
+
+        decision, err := limiter.Allow(s.ctx, Request{Key: "abc"})
+        s.NoError(err)
+        s.Equal(ipKey, decision.Key)
+
+        decision, err = limiter.Allow(s.ctx, Request{Key: "abc", IsToken: true})
+        s.NoError(err)
+        s.Equal(tokenKey, decision.Key)
+        s.NotContains(decision.Key, "abc")
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestRequestCost tests that a request consumes its cost from the limit
+func (s *RateLimiterTestSuite) TestRequestCost() {
+        config := &Config{
+                MaxRequestsPerSecond: 10,
+                BlockDuration:        time.Minute,
+        }
+        limiter := New(s.mockStorage, config)
+        key := "192.168.1.20"
+
+        s.mockStorage.On("CheckAndIncrement", s.ctx, "ip:"+key, int64(5), int64(10), time.Second, time.Minute).Return(storage.Result{Allowed: true, Remaining: 5}, nil)
+
+        decision, err := limiter.Allow(s.ctx, Request{Key: key, Cost: 5})
+        s.NoError(err)
+        s.True(decision.Allowed)
+        s.Equal(5, decision.Remaining)
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestRequestCostAboveLimit tests that costs above the largest limit are
+// capped before they reach storage
+func (s *RateLimiterTestSuite) TestRequestCostAboveLimit() {
+        config := &Config{
+                MaxRequestsPerSecond: 10,
+                Limits:               []Limit{{MaxRequests: 100, Window: time.Hour}},
+        }
+        limiter := New(s.mockStorage, config)
+        key := "192.168.1.22"
+        quotas := []storage.Quota{{Max: 10, Window: time.Second}, {Max: 100, Window: time.Hour}}
+
+        s.mockStorage.On("IsBlocked", s.ctx, "ip:"+key).Return(false, nil)
+        s.mockStorage.On("IncrementQuotas", s.ctx, "ip:"+key, int64(101), quotas).Return(storage.Result{Allowed: false}, nil)
+
+        decision, err := limiter.Allow(s.ctx, Request{Key: key, Cost: math.MaxInt})
+        s.NoError(err)
+        s.False(decision.Allowed)
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestRequestCostTokenBucket tests that a request takes as many tokens as it costs
+func (s *RateLimiterTestSuite) TestRequestCostTokenBucket() {
+        config := &Config{
+                MaxRequestsPerSecond: 5,
+                BlockDuration:        time.Minute,
+                Algorithm:            TokenBucket,
+        }
+        limiter := New(s.mockStorage, config)
+        key := "192.168.1.21"
+
+        s.mockStorage.On("IsBlocked", s.ctx, "ip:"+key).Return(false, nil)
+        s.mockStorage.On("TakeToken", s.ctx, "ip:"+key, int64(3), float64(5), int64(5)).Return(storage.Result{Allowed: true, Remaining: 2}, nil)
+
+        decision, err := limiter.Allow(s.ctx, Request{Key: key, Cost: 3})
+        s.NoError(err)
+        s.True(decision.Allowed)
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestTokenBucketWithinBurst tests that the token bucket allows requests while tokens remain
+func (s *RateLimiterTestSuite) TestTokenBucketWithinBurst() {
+        config := &Config{
+                MaxRequestsPerSecond: 5,
+                BlockDuration:        time.Minute,
+                Algorithm:            TokenBucket,
+                Burst:                20,
+        }
+        limiter := New(s.mockStorage, config)
+        key := "192.168.1.3"
+
+        s.mockStorage.On("IsBlocked", s.ctx, "ip:"+key).Return(false, nil)
+        s.mockStorage.On("TakeToken", s.ctx, "ip:"+key, int64(1), float64(5), int64(20)).Return(storage.Result{Allowed: true, Remaining: 19}, nil)
+
+        allowed, err := limiter.IsAllowed(s.ctx, key, false)
+        s.NoError(err)
+        s.True(allowed)
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestTokenBucketEmpty tests that an empty token bucket blocks the key
+func (s *RateLimiterTestSuite) TestTokenBucketEmpty() {
+        config := &Config{
+                MaxRequestsPerSecond: 5,
+                BlockDuration:        time.Minute,
+                Algorithm:            TokenBucket,
+        }
+        limiter := New(s.mockStorage, config)
+        key := "192.168.1.4"
+
+        // Without an explicit burst the bucket holds MaxRequestsPerSecond tokens
+        s.mockStorage.On("IsBlocked", s.ctx, "ip:"+key).Return(false, nil)
+        s.mockStorage.On("TakeToken", s.ctx, "ip:"+key, int64(1), float64(5), int64(5)).Return(storage.Result{Allowed: false}, nil)
+        s.mockStorage.On("Block", s.ctx, "ip:"+key, time.Minute).Return(nil)
+
+        allowed, err := limiter.IsAllowed(s.ctx, key, false)
+        s.NoError(err)
+        s.False(allowed)
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestSlidingWindowLogOverLimit tests that a full sliding log blocks the key
+func (s *RateLimiterTestSuite) TestSlidingWindowLogOverLimit() {
+        config := &Config{
+                MaxRequestsPerSecond: 5,
+                BlockDuration:        time.Minute,
+                Algorithm:            SlidingWindowLog,
+        }
+        limiter := New(s.mockStorage, config)
+        key := "192.168.1.5"
+
+        s.mockStorage.On("IsBlocked", s.ctx, "ip:"+key).Return(false, nil)
+        s.mockStorage.On("SlidingWindowLog", s.ctx, "ip:"+key, int64(1), int64(5), time.Second).Return(storage.Result{Allowed: false}, nil)
+        s.mockStorage.On("Block", s.ctx, "ip:"+key, time.Minute).Return(nil)
+
+        allowed, err := limiter.IsAllowed(s.ctx, key, false)
+        s.NoError(err)
+        s.False(allowed)
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestTokenAlgorithmOverride tests that a token's algorithm takes precedence over the default one
+func (s *RateLimiterTestSuite) TestTokenAlgorithmOverride() {
+        config := &Config{
+                MaxRequestsPerSecond: 5,
+                BlockDuration:        time.Minute,
+                Algorithm:            FixedWindow,
+                TokenLimits: map[string]TokenConfig{
+                        "test-token": {
+                                MaxRequestsPerSecond: 10,
+                                BlockDuration:        time.Minute,
+                                Algorithm:            SlidingWindowCounter,
+                        },
+                },
+        }
+        limiter := New(s.mockStorage, config)
+        key := "test-token"
+
+        s.mockStorage.On("IsBlocked", s.ctx, "token:"+key).Return(false, nil)
+        s.mockStorage.On("SlidingWindowCounter", s.ctx, "token:"+key, int64(1), int64(10), time.Second).Return(storage.Result{Allowed: true, Remaining: 3}, nil)
+
+        allowed, err := limiter.IsAllowed(s.ctx, key, true)
+        s.NoError(err)
+        s.True(allowed)
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestCustomWindow tests that counters use the configured window
+func (s *RateLimiterTestSuite) TestCustomWindow() {
+        config := &Config{
+                MaxRequestsPerSecond: 1000,
+                Window:               time.Minute,
+                BlockDuration:        time.Minute,
+                TokenLimits: map[string]TokenConfig{
+                        "daily-token": {
+                                MaxRequestsPerSecond: 50000,
+                                Window:               time.Hour * 24,
+                                BlockDuration:        time.Hour,
+                        },
+                },
+        }
+        limiter := New(s.mockStorage, config)
+
+        s.mockStorage.On("CheckAndIncrement", s.ctx, "ip:192.168.1.6", int64(1), int64(1000), time.Minute, time.Minute).Return(storage.Result{Allowed: true}, nil)
+        s.mockStorage.On("CheckAndIncrement", s.ctx, "token:daily-token", int64(1), int64(50000), time.Hour*24, time.Hour).Return(storage.Result{Allowed: false, Blocked: true}, nil)
+
+        allowed, err := limiter.IsAllowed(s.ctx, "192.168.1.6", false)
+        s.NoError(err)
+        s.True(allowed)
+
+        allowed, err = limiter.IsAllowed(s.ctx, "daily-token", true)
+        s.NoError(err)
+        s.False(allowed)
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestTokenBucketRefillsOverWindow tests that the token bucket refills the limit once per window
+func (s *RateLimiterTestSuite) TestTokenBucketRefillsOverWindow() {
+        config := &Config{
+                MaxRequestsPerSecond: 120,
+                Window:               time.Minute,
+                BlockDuration:        time.Minute,
+                Algorithm:            TokenBucket,
+        }
+        limiter := New(s.mockStorage, config)
+        key := "192.168.1.7"
+
+        s.mockStorage.On("IsBlocked", s.ctx, "ip:"+key).Return(false, nil)
+        s.mockStorage.On("TakeToken", s.ctx, "ip:"+key, int64(1), float64(2), int64(120)).Return(storage.Result{Allowed: true}, nil)
+
+        allowed, err := limiter.IsAllowed(s.ctx, key, false)
+        s.NoError(err)
+        s.True(allowed)
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestTieredLimitsAllowed tests that a key with several limits is checked against all of them
+func (s *RateLimiterTestSuite) TestTieredLimitsAllowed() {
+        config := &Config{
+                MaxRequestsPerSecond: 10,
+                BlockDuration:        time.Minute,
+                Limits: []Limit{
+                        {Name: "per-minute", MaxRequests: 500, Window: time.Minute},
+                        {MaxRequests: 10000, Window: time.Hour * 24},
+                },
+        }
+        limiter := New(s.mockStorage, config)
+        key := "192.168.1.8"
+        quotas := []storage.Quota{
+                {Max: 10, Window: time.Second},
+                {Max: 500, Window: time.Minute},
+                {Max: 10000, Window: time.Hour * 24},
+        }
+
+        s.mockStorage.On("IsBlocked", s.ctx, "ip:"+key).Return(false, nil)
+        s.mockStorage.On("IncrementQuotas", s.ctx, "ip:"+key, int64(1), quotas).Return(storage.Result{Allowed: true, Quota: 0, Remaining: 9}, nil)
+
+        decision, err := limiter.Allow(s.ctx, Request{Key: key})
+        s.NoError(err)
+        s.True(decision.Allowed)
+        s.Equal(Limit{MaxRequests: 10, Window: time.Second}, decision.Limit)
+        s.mockStorage.AssertExpectations(s.T())
+}
+
+// TestTieredLimitsReportExceededLimit tests that the limit which denied the request is reported
+func (s *RateLimiterTestSuite) TestTieredLimitsReportExceededLimit() {
+        config := &Config{
+                MaxRequestsPerSecond: 5,
+                BlockDuration:        time.Minute,
+                TokenLimits: map[string]TokenConfig{
+                        "test-token": {
+                                MaxRequestsPerSecond: 10,
+                                BlockDuration:        time.Minute * 2,
+                                Limits: []Limit{
+                                        {Name: "per-minute", MaxRequests: 500, Window: time.Minute},
+                                        {Name: "daily", MaxRequests: 10000, Window: time.Hour * 24},
+                                },
+                        },
+                },
+        }
+        limiter := New(s.mockStorage, config)
+        key := "test-token"
+
+        s.mockStorage.On("IsBlocked", s.ctx, "token:"+key).Return(false, nil)
+        s.mockStorage.On("IncrementQuotas", s.ctx, "token:"+key, int64(1), mock.Anything).Return(storage.Result{Allowed: false, Quota: 2}, nil)
+        s.mockStorage.On("Block", s.ctx, "token:"+key, time.Minute*2).Return(nil)
+
+        decision, err := limiter.Allow(s.ctx, Request{Key: key, IsToken: true})
+        s.NoError(err)
+        s.False(decision.Allowed)
+        s.Equal("daily", decision.Limit.String())
+        s.mockStorage.AssertExpectations(s.T())
+}
+
 // TestGetRemainingRequestsIP tests getting remaining requests for IP-based limiting
 func (s *RateLimiterTestSuite) TestGetRemainingRequestsIP() {
         config := &Config{
@@ -146,7 +625,7 @@ func (s *RateLimiterTestSuite) TestGetRemainingRequestsIP() {
         limiter := New(s.mockStorage, config)
         key := "192.168.1.1"
 
-        s.mockStorage.On("GetRequestCount", s.ctx, key).Return(int64(2), nil)
+        s.mockStorage.On("GetRequestCount", s.ctx, "ip:"+key).Return(int64(2), nil)
 
         remaining, err := limiter.GetRemainingRequests(s.ctx, key, false)
         s.NoError(err)
@@ -167,7 +646,7 @@ func (s *RateLimiterTestSuite) TestGetRemainingRequestsToken() {
         limiter := New(s.mockStorage, config)
         key := "test-token"
 
-        s.mockStorage.On("GetRequestCount", s.ctx, key).Return(int64(4), nil)
+        s.mockStorage.On("GetRequestCount", s.ctx, "token:"+key).Return(int64(4), nil)
 
         remaining, err := limiter.GetRemainingRequests(s.ctx, key, true)
         s.NoError(err)
@@ -0,0 +1,95 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+)
+
+// Event describes a rate limit decision to listeners
+type Event struct {
+	// Request is the request that was checked, with the client key in clear
+	// text even when Config.KeyHasher is set
+	Request Request
+
+	// Decision is the outcome of the check
+	Decision Decision
+
+	// Time is when the check completed
+	Time time.Time
+}
+
+// ErrorEvent describes a check that failed, e.g. because of a storage error
+type ErrorEvent struct {
+	// Request is the request that was checked
+	Request Request
+
+	// Err is the error returned by Allow
+	Err error
+
+	// Time is when the check failed
+	Time time.Time
+}
+
+// Listener is notified of the decisions of a RateLimiter. Listeners run
+// synchronously within Allow, so slow ones should be wrapped in an
+// AsyncListener. Embed NoopListener to implement only some of the methods.
+type Listener interface {
+	// OnAllow is called for every allowed request
+	OnAllow(ctx context.Context, event Event)
+
+	// OnDeny is called for every denied request, including the one that
+	// blocks its key
+	OnDeny(ctx context.Context, event Event)
+
+	// OnBlock is called, after OnDeny, when a request exceeds a limit and
+	// its key is blocked. Requests denied while the key is blocked only
+	// call OnDeny.
+	OnBlock(ctx context.Context, event Event)
+
+	// OnError is called when a check fails
+	OnError(ctx context.Context, event ErrorEvent)
+}
+
+// NoopListener implements every Listener method by doing nothing
+type NoopListener struct{}
+
+func (NoopListener) OnAllow(ctx context.Context, event Event)      {}
+func (NoopListener) OnDeny(ctx context.Context, event Event)       {}
+func (NoopListener) OnBlock(ctx context.Context, event Event)      {}
+func (NoopListener) OnError(ctx context.Context, event ErrorEvent) {}
+
+// WithListener registers a listener of the limiter's decisions. Listeners
+// are notified in the order they were registered.
+func WithListener(listener Listener) Option {
+	return func(r *RateLimiter) {
+		r.listeners = append(r.listeners, listener)
+	}
+}
+
+// notify passes the outcome of a check to the listeners
+func (r *RateLimiter) notify(ctx context.Context, req Request, decision Decision, err error) {
+	if len(r.listeners) == 0 {
+		return
+	}
+
+	now := time.Now()
+	if err != nil {
+		event := ErrorEvent{Request: req, Err: err, Time: now}
+		for _, listener := range r.listeners {
+			listener.OnError(ctx, event)
+		}
+		return
+	}
+
+	event := Event{Request: req, Decision: decision, Time: now}
+	for _, listener := range r.listeners {
+		if decision.Allowed {
+			listener.OnAllow(ctx, event)
+			continue
+		}
+		listener.OnDeny(ctx, event)
+		if decision.Reason == ReasonLimitExceeded && !decision.BlockedUntil.IsZero() {
+			listener.OnBlock(ctx, event)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
+)
+
+// recordingListener records the events it is notified of
+type recordingListener struct {
+	mu     sync.Mutex
+	events []string
+	last   Event
+	err    error
+}
+
+func (l *recordingListener) record(name string, event Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, name)
+	l.last = event
+}
+
+func (l *recordingListener) OnAllow(ctx context.Context, event Event) { l.record("allow", event) }
+func (l *recordingListener) OnDeny(ctx context.Context, event Event)  { l.record("deny", event) }
+func (l *recordingListener) OnBlock(ctx context.Context, event Event) { l.record("block", event) }
+
+func (l *recordingListener) OnError(ctx context.Context, event ErrorEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, "error")
+	l.err = event.Err
+}
+
+func (l *recordingListener) recorded() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.events...)
+}
+
+func newListenerTestLimiter(t *testing.T, opts ...Option) *RateLimiter {
+	t.Helper()
+
+	store := storage.NewMemoryStorage(storage.MemoryOptions{})
+	t.Cleanup(func() { store.Close() })
+	config := &Config{MaxRequestsPerSecond: 1, BlockDuration: time.Minute}
+	return New(store, config, opts...)
+}
+
+func TestListener(t *testing.T) {
+	listener := &recordingListener{}
+	limiter := newListenerTestLimiter(t, WithListener(listener))
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := limiter.IsAllowed(ctx, "10.0.0.1", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := limiter.Allow(ctx, Request{Key: "10.0.0.1", Rule: "missing"}); err == nil {
+		t.Fatal("Allow() with an unknown rule should fail")
+	}
+
+	want := []string{"allow", "deny", "block", "deny", "error"}
+	got := listener.recorded()
+	if len(got) != len(want) {
+		t.Fatalf("Events = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Events = %v, want %v", got, want)
+		}
+	}
+
+	// Events carry the request and the whole decision
+	if listener.last.Request.Key != "10.0.0.1" || listener.last.Decision.Reason != ReasonBlocked {
+		t.Errorf("Last event = %+v, want the blocked request", listener.last)
+	}
+	if listener.last.Decision.BlockedUntil.IsZero() || listener.last.Time.IsZero() {
+		t.Errorf("Last event = %+v, want the block expiration and time", listener.last)
+	}
+	if listener.err == nil {
+		t.Error("Error event should carry the error")
+	}
+}
+
+// slowListener blocks until released
+type slowListener struct {
+	NoopListener
+	release chan struct{}
+	allowed chan Event
+}
+
+func (l *slowListener) OnAllow(ctx context.Context, event Event) {
+	<-l.release
+	l.allowed <- event
+}
+
+func TestAsyncListener(t *testing.T) {
+	slow := &slowListener{release: make(chan struct{}), allowed: make(chan Event, 10)}
+	async := NewAsyncListener(slow, AsyncOptions{QueueSize: 1})
+
+	store := storage.NewMemoryStorage(storage.MemoryOptions{})
+	defer store.Close()
+	limiter := New(store, &Config{MaxRequestsPerSecond: 10}, WithListener(async))
+
+	// A stuck listener doesn't stall requests: the first event is being
+	// delivered, the second is queued and the third is dropped
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			limiter.IsAllowed(ctx, "10.0.0.1", false)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Requests stalled on the listener")
+	}
+	cancel()
+
+	if got := async.Dropped(); got != 1 {
+		t.Errorf("Dropped() = %d, want 1", got)
+	}
+
+	// Close delivers the queued events
+	close(slow.release)
+	async.Close()
+	if got := len(slow.allowed); got != 2 {
+		t.Errorf("Delivered %d events, want 2", got)
+	}
+
+	limiter.IsAllowed(context.Background(), "10.0.0.1", false)
+	if got := async.Dropped(); got != 2 {
+		t.Errorf("Dropped() = %d, want 2 after Close", got)
+	}
+}
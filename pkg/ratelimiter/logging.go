@@ -0,0 +1,109 @@
+package ratelimiter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/netip"
+	"time"
+)
+
+// defaultDenyLogSampling is the share of denials logged by default, one in
+// every defaultDenyLogSampling
+const defaultDenyLogSampling = 100
+
+// WithLogger makes the limiter log with logger:
+//
+//   - keys blocked for exceeding a limit, at Warn
+//   - denied requests, at Info, sampled as set by WithDenyLogSampling
+//   - failed checks, such as storage errors, at Error
+//
+// Keys other than client IPs and subnets, such as tokens and the keys of
+// middleware key extractors, are replaced by a fingerprint in the logs,
+// unless Config.KeyHasher already hashes them.
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *RateLimiter) {
+		r.logger = logger
+	}
+}
+
+// WithDenyLogSampling makes the limiter log one in every n denied requests
+// (default: 100), so that a client hammering the service doesn't flood the
+// logs. One logs every denial. Blocks and errors are always logged.
+func WithDenyLogSampling(n int) Option {
+	return func(r *RateLimiter) {
+		r.denyLogSampling = n
+	}
+}
+
+// log logs the outcome of a check
+func (r *RateLimiter) log(ctx context.Context, req Request, decision Decision, err error) {
+	if r.logger == nil {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("key", r.logKey(req)),
+		slog.String("key_type", req.namespace()),
+		slog.Int64("cost", req.cost()),
+	}
+
+	switch {
+	case err != nil:
+		attrs = append(attrs, slog.Any("error", err))
+		r.logger.LogAttrs(ctx, slog.LevelError, "rate limit check failed", attrs...)
+
+	case decision.Reason == ReasonLimitExceeded && !decision.BlockedUntil.IsZero():
+		attrs = append(attrs,
+			slog.String("rule", decision.Rule),
+			slog.String("limit", decision.Limit.String()),
+			slog.Duration("duration", time.Until(decision.BlockedUntil).Round(time.Millisecond)),
+			slog.Time("blocked_until", decision.BlockedUntil),
+		)
+		if decision.Count > 0 {
+			attrs = append(attrs, slog.Int("count", decision.Count))
+		}
+		r.logger.LogAttrs(ctx, slog.LevelWarn, "rate limit key blocked", attrs...)
+
+	case !decision.Allowed:
+		n := r.denyLogSampling
+		if n <= 0 {
+			n = defaultDenyLogSampling
+		}
+		if (r.denials.Add(1)-1)%uint64(n) != 0 {
+			return
+		}
+		attrs = append(attrs,
+			slog.String("rule", decision.Rule),
+			slog.String("reason", string(decision.Reason)),
+			slog.String("limit", decision.Limit.String()),
+			slog.Duration("retry_after", decision.RetryAfter.Round(time.Millisecond)),
+			slog.Int("sample_rate", n),
+		)
+		r.logger.LogAttrs(ctx, slog.LevelInfo, "rate limit request denied", attrs...)
+	}
+}
+
+// logKey returns the storage key of a request with its key replaced by a
+// fingerprint that identifies it without revealing it. Client IPs and
+// subnets are logged as they are.
+func (r *RateLimiter) logKey(req Request) string {
+	if r.config.KeyHasher != nil || (!req.IsToken && isIPKey(req.Key)) {
+		return req.storageKey(r.config.KeyHasher)
+	}
+
+	sum := sha256.Sum256([]byte(req.Key))
+	req.Key = "sha256:" + hex.EncodeToString(sum[:6])
+	return req.storageKey(nil)
+}
+
+// isIPKey reports whether key is a client IP or subnet, as opposed to a key
+// of a middleware key extractor, which may hold session IDs or other secrets
+func isIPKey(key string) bool {
+	if _, err := netip.ParseAddr(key); err == nil {
+		return true
+	}
+	_, err := netip.ParsePrefix(key)
+	return err == nil
+}
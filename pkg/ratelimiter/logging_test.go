@@ -0,0 +1,125 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
+)
+
+// logRecords decodes the records written by a JSON handler
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	store := storage.NewMemoryStorage(storage.MemoryOptions{})
+	defer store.Close()
+	config := &Config{
+		MaxRequestsPerSecond: 10,
+		BlockDuration:        time.Minute,
+		TokenLimits:          map[string]TokenConfig{"secret-token": {MaxRequestsPerSecond: 1, BlockDuration: time.Minute}},
+	}
+	limiter := New(store, config, WithLogger(logger), WithDenyLogSampling(2))
+
+	ctx := context.Background()
+	for i := 0; i < 6; i++ {
+		if _, err := limiter.IsAllowed(ctx, "secret-token", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := limiter.Allow(ctx, Request{Key: "10.0.0.1", Rule: "missing"}); err == nil {
+		t.Fatal("Allow() with an unknown rule should fail")
+	}
+
+	if strings.Contains(buf.String(), "secret-token") {
+		t.Errorf("Logs contain the token:\n%s", buf.String())
+	}
+
+	// One block, two of the four later denials and the error
+	records := logRecords(t, &buf)
+	if len(records) != 4 {
+		t.Fatalf("Got %d records, want 4:\n%s", len(records), buf.String())
+	}
+
+	block := records[0]
+	if block["level"] != "WARN" || block["msg"] != "rate limit key blocked" {
+		t.Errorf("First record = %v, want the block", block)
+	}
+	if block["key_type"] != KeyTypeToken || block["rule"] != RuleToken || block["count"] != 1.0 {
+		t.Errorf("Block record = %v, want the token key, rule and count", block)
+	}
+	if !strings.HasPrefix(block["key"].(string), "token:sha256:") {
+		t.Errorf("Block key = %v, want a token fingerprint", block["key"])
+	}
+
+	for _, denial := range records[1:3] {
+		if denial["level"] != "INFO" || denial["reason"] != string(ReasonBlocked) || denial["sample_rate"] != 2.0 {
+			t.Errorf("Record = %v, want a sampled denial", denial)
+		}
+	}
+	if records[1]["key"] != block["key"] {
+		t.Error("The fingerprint of a token should be stable")
+	}
+
+	if records[3]["level"] != "ERROR" || records[3]["error"] == nil {
+		t.Errorf("Last record = %v, want the error", records[3])
+	}
+	if records[3]["key"] != "ip/missing:10.0.0.1" {
+		t.Errorf("Error key = %v, want the client IP in clear", records[3]["key"])
+	}
+}
+
+func TestLoggerFingerprintsExtractorKeys(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	store := storage.NewMemoryStorage(storage.MemoryOptions{})
+	defer store.Close()
+	config := &Config{MaxRequestsPerSecond: 1, BlockDuration: time.Minute}
+	limiter := New(store, config, WithLogger(logger))
+
+	ctx := context.Background()
+	for _, key := range []string{"cookie:session:s3cr3t", "2001:db8::/64"} {
+		for i := 0; i < 2; i++ {
+			if _, err := limiter.Allow(ctx, Request{Key: key}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if strings.Contains(buf.String(), "s3cr3t") {
+		t.Errorf("Logs contain the session cookie:\n%s", buf.String())
+	}
+	records := logRecords(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("Got %d records, want 2:\n%s", len(records), buf.String())
+	}
+	if key := records[0]["key"].(string); !strings.HasPrefix(key, "ip:sha256:") {
+		t.Errorf("Extractor key = %v, want a fingerprint", key)
+	}
+	if records[1]["key"] != "ip:2001:db8::/64" {
+		t.Errorf("Subnet key = %v, want it in clear", records[1]["key"])
+	}
+}
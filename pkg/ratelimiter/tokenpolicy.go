@@ -0,0 +1,48 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UnknownTokenPolicy decides how requests carrying a token without limits of
+// its own are limited. Without a policy, anyone could dodge the IP limits by
+// sending a new made-up token with every request.
+type UnknownTokenPolicy string
+
+const (
+	// UnknownTokenDefault limits unknown tokens with the default limits,
+	// each with its own counter (default)
+	UnknownTokenDefault UnknownTokenPolicy = "default"
+
+	// UnknownTokenReject rejects requests with unknown tokens
+	UnknownTokenReject UnknownTokenPolicy = "reject"
+
+	// UnknownTokenIP limits requests with unknown tokens like requests
+	// without a token, by client IP
+	UnknownTokenIP UnknownTokenPolicy = "ip"
+
+	// UnknownTokenLimit limits requests with unknown tokens by client IP
+	// against Config.UnknownTokenLimit, separately from requests without a
+	// token
+	UnknownTokenLimit UnknownTokenPolicy = "limit"
+)
+
+// ParseUnknownTokenPolicy converts a string such as "reject" into an
+// UnknownTokenPolicy
+func ParseUnknownTokenPolicy(s string) (UnknownTokenPolicy, error) {
+	switch p := UnknownTokenPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case UnknownTokenDefault, UnknownTokenReject, UnknownTokenIP, UnknownTokenLimit:
+		return p, nil
+	case "":
+		return UnknownTokenDefault, nil
+	default:
+		return "", fmt.Errorf("unknown token policy %q", s)
+	}
+}
+
+// IsKnownToken reports whether a token has limits of its own
+func (c *Config) IsKnownToken(token string) bool {
+	_, exists := c.TokenLimits[token]
+	return exists
+}
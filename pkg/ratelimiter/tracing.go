@@ -0,0 +1,59 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the limiter spans
+const tracerName = "github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/ratelimiter"
+
+// noopSpan is returned when tracing is disabled
+var noopSpan = trace.SpanFromContext(context.Background())
+
+// WithTracerProvider makes the limiter create a "ratelimiter.Allow" span for
+// every check, as a child of the span in the request's context. Without it,
+// no spans are created.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(r *RateLimiter) {
+		r.tracer = provider.Tracer(tracerName)
+	}
+}
+
+// startSpan starts the span of a check. The client key is left out, since it
+// may be an API token. Without a tracer, ctx is returned as is with a span
+// that records nothing.
+func (r *RateLimiter) startSpan(ctx context.Context, req Request) (context.Context, trace.Span) {
+	if r.tracer == nil {
+		return ctx, noopSpan
+	}
+	return r.tracer.Start(ctx, "ratelimiter.Allow", trace.WithAttributes(
+		attribute.String("ratelimiter.key_type", req.namespace()),
+		attribute.Int64("ratelimiter.cost", req.cost()),
+	))
+}
+
+// endSpan records the outcome of a check on its span
+func endSpan(span trace.Span, decision Decision, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("ratelimiter.rule", decision.Rule),
+		attribute.Bool("ratelimiter.allowed", decision.Allowed),
+		attribute.String("ratelimiter.reason", string(decision.Reason)),
+		attribute.Int("ratelimiter.limit", decision.Limit.MaxRequests),
+		attribute.Int("ratelimiter.remaining", decision.Remaining),
+		attribute.Int("ratelimiter.count", decision.Count),
+	)
+	if !decision.BlockedUntil.IsZero() {
+		span.SetAttributes(attribute.String("ratelimiter.blocked_until", decision.BlockedUntil.UTC().Format(time.RFC3339)))
+	}
+}
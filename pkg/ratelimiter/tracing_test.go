@@ -0,0 +1,64 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracerProvider(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	store := storage.NewMemoryStorage(storage.MemoryOptions{})
+	defer store.Close()
+	config := &Config{MaxRequestsPerSecond: 1, BlockDuration: time.Minute}
+	limiter := New(store, config, WithTracerProvider(provider))
+
+	// The span is a child of the one in the context, e.g. the HTTP server's
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "GET /")
+	if _, err := limiter.IsAllowed(ctx, "10.0.0.1", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := limiter.IsAllowed(ctx, "10.0.0.1", false); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("Got %d spans, want 3", len(spans))
+	}
+	span := spans[1]
+	if span.Name() != "ratelimiter.Allow" {
+		t.Errorf("Span name = %q, want ratelimiter.Allow", span.Name())
+	}
+	if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("Span should be a child of the context's span")
+	}
+
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range span.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	want := map[attribute.Key]attribute.Value{
+		"ratelimiter.key_type": attribute.StringValue(KeyTypeIP),
+		"ratelimiter.rule":     attribute.StringValue(RuleDefault),
+		"ratelimiter.allowed":  attribute.BoolValue(false),
+		"ratelimiter.reason":   attribute.StringValue(string(ReasonLimitExceeded)),
+		"ratelimiter.count":    attribute.IntValue(1),
+	}
+	for key, value := range want {
+		if attrs[key] != value {
+			t.Errorf("Attribute %s = %v, want %v", key, attrs[key].Emit(), value.Emit())
+		}
+	}
+	if _, ok := attrs["ratelimiter.blocked_until"]; !ok {
+		t.Error("Blocked decisions should have a blocked_until attribute")
+	}
+}
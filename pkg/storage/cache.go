@@ -0,0 +1,474 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheMaxBlockedKeys = 10000
+	defaultCacheMaxPending     = 10
+	defaultCacheSyncTimeout    = time.Second
+)
+
+// CacheOptions configures a CachedStorage
+type CacheOptions struct {
+	// MaxBlockedKeys bounds the number of blocks kept in memory (default:
+	// 10000). Once reached, expired blocks are dropped first, then arbitrary
+	// ones.
+	MaxBlockedKeys int
+
+	// BlockRefresh makes cached blocks be checked against the backend again
+	// after this long, so that blocks lifted in the backend are noticed before
+	// they expire. When zero, blocks are trusted until they expire.
+	BlockRefresh time.Duration
+
+	// SyncInterval enables batched increments for CheckAndIncrement: requests
+	// that fit in the limit are counted locally and sent to the backend in
+	// one increment per key every SyncInterval. When zero, every request
+	// reaches the backend.
+	SyncInterval time.Duration
+
+	// MaxPending is the number of units an instance may allow for a key
+	// before sending them to the backend with the next request (default:
+	// 10). Each instance may let a key exceed its limit by this much until
+	// the next sync.
+	MaxPending int64
+
+	// SyncTimeout bounds each sync with the backend, including the last one
+	// made by Close, so that a backend that hangs doesn't hang them too
+	// (default: 1s). Increments that miss it are retried with the next sync.
+	SyncTimeout time.Duration
+
+	// OnSyncError is called when a batch of increments fails to reach the
+	// backend. The increments are retried with the next sync.
+	OnSyncError func(key string, err error)
+}
+
+// CachedStorage keeps the blocks of another Storage, usually a RedisStorage,
+// in process memory, so that requests from blocked clients are denied without
+// a round trip. Optionally it also counts fixed-window requests locally and
+// syncs them with the backend periodically, trading accuracy for fewer calls
+// at high request rates.
+//
+// Blocks are cached until they expire: a block lifted directly in the
+// backend is only noticed after BlockRefresh.
+type CachedStorage struct {
+	backend Storage
+	opts    CacheOptions
+
+	mu       sync.Mutex
+	blocks   map[string]cachedBlock
+	counters map[string]*batchedCounter
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type cachedBlock struct {
+	until    time.Time // when the block expires
+	resetAt  time.Time // when the key's window ends, zero when unknown
+	cachedAt time.Time
+}
+
+// batchedCounter is the local view of a key's fixed window
+type batchedCounter struct {
+	synced  int64 // count last reported by the backend
+	pending int64 // units allowed locally and not sent to the backend yet
+	resetAt time.Time
+
+	limit         int64
+	window        time.Duration
+	blockDuration time.Duration
+}
+
+// NewCachedStorage wraps backend in a local cache. With batched increments
+// enabled, call Close to send the pending increments and stop syncing.
+func NewCachedStorage(backend Storage, opts CacheOptions) *CachedStorage {
+	if opts.MaxBlockedKeys <= 0 {
+		opts.MaxBlockedKeys = defaultCacheMaxBlockedKeys
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = defaultCacheMaxPending
+	}
+	if opts.SyncTimeout <= 0 {
+		opts.SyncTimeout = defaultCacheSyncTimeout
+	}
+
+	c := &CachedStorage{
+		backend:  backend,
+		opts:     opts,
+		blocks:   make(map[string]cachedBlock),
+		counters: make(map[string]*batchedCounter),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if opts.SyncInterval > 0 {
+		go c.syncer(opts.SyncInterval)
+	} else {
+		close(c.done)
+	}
+	return c
+}
+
+func (c *CachedStorage) GetRequestCount(ctx context.Context, key string) (int64, error) {
+	count, err := c.backend.GetRequestCount(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	// Include the increments the backend hasn't seen yet
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if counter := c.counters[key]; counter != nil && time.Now().Before(counter.resetAt) {
+		count += counter.pending
+	}
+	return count, nil
+}
+
+func (c *CachedStorage) IncrementRequestCount(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	return c.backend.IncrementRequestCount(ctx, key, expiration)
+}
+
+func (c *CachedStorage) IncrByWithCost(ctx context.Context, key string, cost int64, expiration time.Duration) (int64, error) {
+	return c.backend.IncrByWithCost(ctx, key, cost, expiration)
+}
+
+func (c *CachedStorage) CheckAndIncrement(ctx context.Context, key string, cost, limit int64, window, blockDuration time.Duration) (Result, error) {
+	now := time.Now()
+	if block, ok := c.cachedBlock(key, now); ok {
+		return block.result(now), nil
+	}
+
+	var pending int64
+	if c.opts.SyncInterval > 0 {
+		c.mu.Lock()
+		counter := c.counters[key]
+		if counter != nil && now.Before(counter.resetAt) {
+			// Allow locally while the request fits in the last known count
+			count := counter.synced + counter.pending + cost
+			if count <= limit && counter.pending+cost <= c.opts.MaxPending {
+				counter.pending += cost
+				result := Result{
+					Allowed:    true,
+					Remaining:  limit - count,
+					ResetAfter: counter.resetAt.Sub(now),
+				}
+				c.mu.Unlock()
+				return result, nil
+			}
+
+			// Otherwise send the pending units before this request
+			pending = counter.pending
+			counter.pending = 0
+		}
+		c.mu.Unlock()
+	}
+
+	if pending > 0 {
+		if _, err := c.backend.IncrByWithCost(ctx, key, pending, window); err != nil {
+			c.restore(key, pending)
+			return Result{}, err
+		}
+	}
+
+	result, err := c.backend.CheckAndIncrement(ctx, key, cost, limit, window, blockDuration)
+	if err != nil {
+		return Result{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.observe(key, result, time.Now())
+	if c.opts.SyncInterval > 0 {
+		c.synced(key, result, limit, window, blockDuration)
+	}
+	return result, nil
+}
+
+func (c *CachedStorage) TakeToken(ctx context.Context, key string, cost int64, rate float64, capacity int64) (Result, error) {
+	return c.backend.TakeToken(ctx, key, cost, rate, capacity)
+}
+
+func (c *CachedStorage) SlidingWindowLog(ctx context.Context, key string, cost, limit int64, window time.Duration) (Result, error) {
+	return c.backend.SlidingWindowLog(ctx, key, cost, limit, window)
+}
+
+func (c *CachedStorage) SlidingWindowCounter(ctx context.Context, key string, cost, limit int64, window time.Duration) (Result, error) {
+	return c.backend.SlidingWindowCounter(ctx, key, cost, limit, window)
+}
+
+func (c *CachedStorage) IncrementQuotas(ctx context.Context, key string, cost int64, quotas []Quota) (Result, error) {
+	return c.backend.IncrementQuotas(ctx, key, cost, quotas)
+}
+
+func (c *CachedStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	if _, ok := c.cachedBlock(key, time.Now()); ok {
+		return true, nil
+	}
+	return c.backend.IsBlocked(ctx, key)
+}
+
+func (c *CachedStorage) Block(ctx context.Context, key string, duration time.Duration) error {
+	if err := c.backend.Block(ctx, key, duration); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.cacheBlock(key, cachedBlock{until: now.Add(duration), cachedAt: now})
+	return nil
+}
+
+func (c *CachedStorage) GetBlockTTL(ctx context.Context, key string) (time.Duration, error) {
+	now := time.Now()
+	if block, ok := c.cachedBlock(key, now); ok {
+		return block.until.Sub(now), nil
+	}
+
+	ttl, err := c.backend.GetBlockTTL(ctx, key)
+	if err != nil || ttl <= 0 {
+		return ttl, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cacheBlock(key, cachedBlock{until: now.Add(ttl), cachedAt: now})
+	return ttl, nil
+}
+
+func (c *CachedStorage) GetWindowTTL(ctx context.Context, key string) (time.Duration, error) {
+	now := time.Now()
+	if block, ok := c.cachedBlock(key, now); ok && !block.resetAt.IsZero() {
+		return max(0, block.resetAt.Sub(now)), nil
+	}
+
+	ttl, err := c.backend.GetWindowTTL(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	// Remember the window of a cached block for the next blocked requests
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if block, ok := c.blocks[key]; ok {
+		block.resetAt = now.Add(ttl)
+		c.blocks[key] = block
+	}
+	return ttl, nil
+}
+
+// Unblock lifts the block in the backend and in the local cache. Other
+// instances keep their cached block until it expires or BlockRefresh passes.
+func (c *CachedStorage) Unblock(ctx context.Context, key string) error {
+	if err := c.backend.Unblock(ctx, key); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.blocks, key)
+	return nil
+}
+
+// Reset resets the key in the backend and discards its pending increments
+func (c *CachedStorage) Reset(ctx context.Context, key string, quotas []Quota) error {
+	c.mu.Lock()
+	delete(c.counters, key)
+	c.mu.Unlock()
+
+	return c.backend.Reset(ctx, key, quotas)
+}
+
+func (c *CachedStorage) ListBlocked(ctx context.Context, limit int) ([]BlockedKey, error) {
+	return c.backend.ListBlocked(ctx, limit)
+}
+
+// Close sends the pending increments to the backend, then closes it. Later
+// calls return the error of the first one.
+func (c *CachedStorage) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		<-c.done
+		c.closeErr = c.backend.Close()
+	})
+	return c.closeErr
+}
+
+// cachedBlock returns the cached block of key, if it is still valid
+func (c *CachedStorage) cachedBlock(key string, now time.Time) (cachedBlock, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	block, ok := c.blocks[key]
+	if !ok {
+		return cachedBlock{}, false
+	}
+	if !now.Before(block.until) ||
+		(c.opts.BlockRefresh > 0 && now.Sub(block.cachedAt) >= c.opts.BlockRefresh) {
+		delete(c.blocks, key)
+		return cachedBlock{}, false
+	}
+	return block, true
+}
+
+// result describes a request denied by the block
+func (b cachedBlock) result(now time.Time) Result {
+	result := Result{
+		Blocked:    true,
+		WasBlocked: true,
+		BlockTTL:   b.until.Sub(now),
+	}
+	if !b.resetAt.IsZero() {
+		result.ResetAfter = max(0, b.resetAt.Sub(now))
+	}
+	return result
+}
+
+// cacheBlock stores the block of key, making room for it if needed. The
+// caller must hold c.mu.
+func (c *CachedStorage) cacheBlock(key string, block cachedBlock) {
+	if _, ok := c.blocks[key]; !ok && len(c.blocks) >= c.opts.MaxBlockedKeys {
+		c.removeExpiredBlocks(block.cachedAt)
+		for k := range c.blocks {
+			if len(c.blocks) < c.opts.MaxBlockedKeys {
+				break
+			}
+			delete(c.blocks, k)
+		}
+	}
+	c.blocks[key] = block
+}
+
+// removeExpiredBlocks drops the blocks that expired by now. The caller must
+// hold c.mu.
+func (c *CachedStorage) removeExpiredBlocks(now time.Time) {
+	for key, block := range c.blocks {
+		if !now.Before(block.until) {
+			delete(c.blocks, key)
+		}
+	}
+}
+
+// observe caches the block reported by a fixed-window result. The caller must
+// hold c.mu.
+func (c *CachedStorage) observe(key string, result Result, now time.Time) {
+	if !result.Blocked || result.BlockTTL <= 0 {
+		return
+	}
+	block := cachedBlock{until: now.Add(result.BlockTTL), cachedAt: now}
+	if result.ResetAfter > 0 {
+		block.resetAt = now.Add(result.ResetAfter)
+	}
+	c.cacheBlock(key, block)
+}
+
+// synced updates the local view of a key's window with a result from the
+// backend. The caller must hold c.mu.
+func (c *CachedStorage) synced(key string, result Result, limit int64, window, blockDuration time.Duration) {
+	if result.WasBlocked || result.ResetAfter <= 0 {
+		delete(c.counters, key)
+		return
+	}
+
+	counter := c.counters[key]
+	if counter == nil {
+		counter = &batchedCounter{}
+		c.counters[key] = counter
+	}
+	counter.resetAt = time.Now().Add(result.ResetAfter)
+	counter.limit = limit
+	counter.window = window
+	counter.blockDuration = blockDuration
+
+	// The backend doesn't report the count of denied requests, which is
+	// over the limit anyway, so later requests go to the backend
+	counter.synced = limit
+	if result.Allowed {
+		counter.synced = limit - result.Remaining
+	}
+}
+
+// restore puts back pending units that failed to reach the backend
+func (c *CachedStorage) restore(key string, pending int64) {
+	if pending == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if counter := c.counters[key]; counter != nil {
+		counter.pending += pending
+	}
+}
+
+// syncer sends the pending increments every interval until Close is called
+func (c *CachedStorage) syncer(interval time.Duration) {
+	defer close(c.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.sync()
+		case <-c.stop:
+			c.sync()
+			return
+		}
+	}
+}
+
+// sync sends the pending increments of every key to the backend
+func (c *CachedStorage) sync() {
+	type batch struct {
+		key     string
+		pending int64
+		counter batchedCounter
+	}
+
+	now := time.Now()
+	var batches []batch
+
+	c.mu.Lock()
+	for key, counter := range c.counters {
+		// Increments left from a window that is over no longer count
+		if !now.Before(counter.resetAt) {
+			delete(c.counters, key)
+			continue
+		}
+		if counter.pending > 0 {
+			batches = append(batches, batch{key: key, pending: counter.pending, counter: *counter})
+			counter.pending = 0
+		}
+	}
+	c.removeExpiredBlocks(now)
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.SyncTimeout)
+	defer cancel()
+	for _, b := range batches {
+		// The pending units were allowed already, so they are counted even
+		// when they no longer fit. The next request then goes to the backend,
+		// which denies it and blocks the key.
+		count, err := c.backend.IncrByWithCost(ctx, b.key, b.pending, b.counter.window)
+		if err != nil {
+			c.restore(b.key, b.pending)
+			if c.opts.OnSyncError != nil {
+				c.opts.OnSyncError(b.key, err)
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		if counter := c.counters[b.key]; counter != nil {
+			counter.synced = count
+		}
+		c.mu.Unlock()
+	}
+}
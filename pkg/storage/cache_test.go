@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingStorage is a MemoryStorage that counts the calls reaching it
+type countingStorage struct {
+	*MemoryStorage
+	calls atomic.Int64
+}
+
+func (s *countingStorage) CheckAndIncrement(ctx context.Context, key string, cost, limit int64, window, blockDuration time.Duration) (Result, error) {
+	s.calls.Add(1)
+	return s.MemoryStorage.CheckAndIncrement(ctx, key, cost, limit, window, blockDuration)
+}
+
+func (s *countingStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	s.calls.Add(1)
+	return s.MemoryStorage.IsBlocked(ctx, key)
+}
+
+func (s *countingStorage) GetBlockTTL(ctx context.Context, key string) (time.Duration, error) {
+	s.calls.Add(1)
+	return s.MemoryStorage.GetBlockTTL(ctx, key)
+}
+
+// keptStorage keeps its state when closed, so that tests can inspect it
+type keptStorage struct {
+	*countingStorage
+}
+
+func (keptStorage) Close() error {
+	return nil
+}
+
+func TestCachedStorageBlocks(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{MemoryStorage: NewMemoryStorage(MemoryOptions{})}
+	store := NewCachedStorage(backend, CacheOptions{})
+	defer store.Close()
+
+	// Exceeding the limit blocks the key
+	for i := 0; i < 2; i++ {
+		if _, err := store.CheckAndIncrement(ctx, "key", 1, 1, time.Minute, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	calls := backend.calls.Load()
+
+	// Blocked requests are answered locally
+	result, err := store.CheckAndIncrement(ctx, "key", 1, 1, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed || !result.WasBlocked || result.BlockTTL <= 0 || result.ResetAfter <= 0 {
+		t.Errorf("CheckAndIncrement() = %+v, want a blocked result with TTLs", result)
+	}
+	if blocked, _ := store.IsBlocked(ctx, "key"); !blocked {
+		t.Error("IsBlocked() = false, want true")
+	}
+	if ttl, _ := store.GetBlockTTL(ctx, "key"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("GetBlockTTL() = %v, want up to a minute", ttl)
+	}
+	if got := backend.calls.Load(); got != calls {
+		t.Errorf("Backend got %d calls for a cached block, want none", got-calls)
+	}
+
+	// Blocks found through the backend are cached too
+	if err := backend.Block(ctx, "other", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	store.GetBlockTTL(ctx, "other")
+	calls = backend.calls.Load()
+	if blocked, _ := store.IsBlocked(ctx, "other"); !blocked {
+		t.Error("IsBlocked() = false, want true")
+	}
+	if got := backend.calls.Load(); got != calls {
+		t.Error("IsBlocked() should use the block cached by GetBlockTTL")
+	}
+}
+
+func TestCachedStorageBlockExpiration(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{MemoryStorage: NewMemoryStorage(MemoryOptions{})}
+	store := NewCachedStorage(backend, CacheOptions{})
+	defer store.Close()
+
+	if err := store.Block(ctx, "key", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if blocked, _ := store.IsBlocked(ctx, "key"); !blocked {
+		t.Error("IsBlocked() = false, want true")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if blocked, _ := store.IsBlocked(ctx, "key"); blocked {
+		t.Error("IsBlocked() = true after the block expired")
+	}
+}
+
+func TestCachedStorageBatching(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{MemoryStorage: NewMemoryStorage(MemoryOptions{})}
+	defer backend.Close()
+	store := NewCachedStorage(keptStorage{backend}, CacheOptions{SyncInterval: time.Hour, MaxPending: 5})
+
+	// The first request reaches the backend, the next ones are batched
+	for i := 0; i < 6; i++ {
+		result, err := store.CheckAndIncrement(ctx, "key", 1, 100, time.Minute, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != int64(100-i-1) {
+			t.Errorf("Request %d: got %+v, want allowed with %d remaining", i+1, result, 100-i-1)
+		}
+	}
+	if got := backend.calls.Load(); got != 1 {
+		t.Errorf("Backend got %d calls, want 1", got)
+	}
+	if count, _ := backend.GetRequestCount(ctx, "key"); count != 1 {
+		t.Errorf("Backend count = %d, want 1 before syncing", count)
+	}
+	if count, _ := store.GetRequestCount(ctx, "key"); count != 6 {
+		t.Errorf("GetRequestCount() = %d, want 6 with pending increments", count)
+	}
+
+	// Once MaxPending is reached, the batch goes with the next request
+	if _, err := store.CheckAndIncrement(ctx, "key", 1, 100, time.Minute, 0); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := backend.GetRequestCount(ctx, "key"); count != 7 {
+		t.Errorf("Backend count = %d, want 7", count)
+	}
+
+	// Close sends the rest
+	store.CheckAndIncrement(ctx, "key", 2, 100, time.Minute, 0)
+	store.Close()
+	if count, _ := backend.GetRequestCount(ctx, "key"); count != 9 {
+		t.Errorf("Backend count = %d, want 9 after Close", count)
+	}
+}
+
+// hangingStorage is a MemoryStorage whose increments hang until their
+// context is done while hanging
+type hangingStorage struct {
+	*MemoryStorage
+	hanging atomic.Bool
+}
+
+func (s *hangingStorage) CheckAndIncrement(ctx context.Context, key string, cost, limit int64, window, blockDuration time.Duration) (Result, error) {
+	if s.hanging.Load() {
+		<-ctx.Done()
+		return Result{}, ctx.Err()
+	}
+	return s.MemoryStorage.CheckAndIncrement(ctx, key, cost, limit, window, blockDuration)
+}
+
+func (s *hangingStorage) IncrByWithCost(ctx context.Context, key string, cost int64, expiration time.Duration) (int64, error) {
+	if s.hanging.Load() {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	return s.MemoryStorage.IncrByWithCost(ctx, key, cost, expiration)
+}
+
+func TestCachedStorageCloseWithHangingBackend(t *testing.T) {
+	ctx := context.Background()
+	backend := &hangingStorage{MemoryStorage: NewMemoryStorage(MemoryOptions{})}
+	var syncErrors atomic.Int64
+	store := NewCachedStorage(backend, CacheOptions{
+		SyncInterval: time.Hour,
+		SyncTimeout:  20 * time.Millisecond,
+		OnSyncError:  func(key string, err error) { syncErrors.Add(1) },
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := store.CheckAndIncrement(ctx, "key", 1, 100, time.Minute, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The last sync gives up on the backend instead of hanging Close
+	backend.hanging.Store(true)
+	closed := make(chan struct{})
+	go func() {
+		store.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close() hangs with the backend")
+	}
+	if syncErrors.Load() != 1 {
+		t.Errorf("OnSyncError called %d times, want 1", syncErrors.Load())
+	}
+}
+
+// closingStorage is a MemoryStorage that counts how many times it is closed
+type closingStorage struct {
+	*MemoryStorage
+	closes atomic.Int64
+}
+
+func (s *closingStorage) Close() error {
+	s.closes.Add(1)
+	return s.MemoryStorage.Close()
+}
+
+func TestCachedStorageClosesBackendOnce(t *testing.T) {
+	backend := &closingStorage{MemoryStorage: NewMemoryStorage(MemoryOptions{})}
+	store := NewCachedStorage(backend, CacheOptions{SyncInterval: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := backend.closes.Load(); got != 1 {
+		t.Errorf("Backend closed %d times, want 1", got)
+	}
+}
+
+func TestCachedStorageSyncCountsAllowedUnits(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{MemoryStorage: NewMemoryStorage(MemoryOptions{})}
+	defer backend.Close()
+	store := NewCachedStorage(keptStorage{backend}, CacheOptions{SyncInterval: time.Hour, MaxPending: 5})
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.CheckAndIncrement(ctx, "key", 1, 5, time.Minute, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Another instance uses up the limit before the batch is sent
+	if _, err := backend.IncrByWithCost(ctx, "key", 4, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// The batch was allowed already, so it is counted although it no longer fits
+	store.Close()
+	if count, _ := backend.GetRequestCount(ctx, "key"); count != 7 {
+		t.Errorf("Backend count = %d, want 7 after Close", count)
+	}
+}
+
+func TestCachedStorageBatchingLimit(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryStorage(MemoryOptions{})
+	store := NewCachedStorage(backend, CacheOptions{SyncInterval: 20 * time.Millisecond})
+	defer store.Close()
+
+	// Requests that would exceed the limit are checked by the backend
+	for i := 0; i < 3; i++ {
+		result, err := store.CheckAndIncrement(ctx, "key", 1, 3, time.Minute, time.Minute)
+		if err != nil || !result.Allowed {
+			t.Fatalf("Request %d: got %+v, %v, want allowed", i+1, result, err)
+		}
+	}
+	result, err := store.CheckAndIncrement(ctx, "key", 1, 3, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed || !result.Blocked {
+		t.Errorf("CheckAndIncrement() = %+v, want denied and blocked", result)
+	}
+	if blocked, _ := backend.IsBlocked(ctx, "key"); !blocked {
+		t.Error("Backend should block the key")
+	}
+
+	// Pending increments are synced periodically
+	store.CheckAndIncrement(ctx, "other", 1, 10, time.Minute, 0)
+	store.CheckAndIncrement(ctx, "other", 1, 10, time.Minute, 0)
+	time.Sleep(60 * time.Millisecond)
+	if count, _ := backend.GetRequestCount(ctx, "other"); count != 2 {
+		t.Errorf("Backend count = %d, want 2 after syncing", count)
+	}
+}
@@ -0,0 +1,321 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a CircuitBreakerStorage without fallback
+// while its circuit is open
+var ErrCircuitOpen = errors.New("storage circuit breaker is open")
+
+// CircuitState is the state of a CircuitBreakerStorage
+type CircuitState int
+
+const (
+	// CircuitClosed sends every call to the backend
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen sends every call to the fallback, without trying the
+	// backend
+	CircuitOpen
+
+	// CircuitHalfOpen sends a probe call at a time to the backend to find
+	// out whether it recovered, and the other calls to the fallback
+	CircuitHalfOpen
+)
+
+// String returns "closed", "open" or "half-open"
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreakerOptions configures a CircuitBreakerStorage
+type CircuitBreakerOptions struct {
+	// FailureThreshold is the number of consecutive failed or slow calls
+	// that opens the circuit (default: 5)
+	FailureThreshold int
+
+	// SlowCallDuration makes calls that take longer count as failures, even
+	// when they succeed. When zero, only errors count.
+	SlowCallDuration time.Duration
+
+	// OpenDuration is how long the circuit stays open before probing the
+	// backend again (default: 30s)
+	OpenDuration time.Duration
+
+	// HalfOpenSuccesses is the number of consecutive successful probes that
+	// closes the circuit again (default: 1)
+	HalfOpenSuccesses int
+
+	// Fallback serves the calls the backend doesn't while the circuit is
+	// open, e.g. a MemoryStorage. When nil, those calls fail with
+	// ErrCircuitOpen.
+	Fallback Storage
+
+	// OnStateChange is called, while holding the breaker's lock, whenever the
+	// circuit changes state
+	OnStateChange func(from, to CircuitState)
+}
+
+// CircuitBreakerStorage stops calling a backend that keeps failing or
+// responding slowly, so that an outage doesn't add the backend's timeout to
+// every request. While the circuit is open, calls go to a fallback backend,
+// which only knows about the requests it served itself.
+type CircuitBreakerStorage struct {
+	backend Storage
+	opts    CircuitBreakerOptions
+
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int       // consecutive failures while closed
+	successes int       // consecutive successful probes while half-open
+	openedAt  time.Time // when the circuit last opened
+	probing   bool      // whether a probe is in flight
+}
+
+// NewCircuitBreakerStorage wraps backend, which can be a RedisStorage or any
+// other Storage, in a circuit breaker
+func NewCircuitBreakerStorage(backend Storage, opts CircuitBreakerOptions) *CircuitBreakerStorage {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 5
+	}
+	if opts.OpenDuration <= 0 {
+		opts.OpenDuration = 30 * time.Second
+	}
+	if opts.HalfOpenSuccesses <= 0 {
+		opts.HalfOpenSuccesses = 1
+	}
+	return &CircuitBreakerStorage{backend: backend, opts: opts}
+}
+
+// State returns the current state of the circuit
+func (c *CircuitBreakerStorage) State() CircuitState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// acquire returns the storage a call should go to, which is nil when the
+// circuit is open and there is no fallback, whether it is the backend and
+// whether the call is a probe
+func (c *CircuitBreakerStorage) acquire() (target Storage, primary, probe bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= c.opts.OpenDuration {
+		c.setState(CircuitHalfOpen)
+	}
+
+	switch {
+	case c.state == CircuitClosed:
+		return c.backend, true, false
+	case c.state == CircuitHalfOpen && !c.probing:
+		c.probing = true
+		return c.backend, true, true
+	default:
+		return c.opts.Fallback, false, false
+	}
+}
+
+// record updates the circuit with the outcome of a call to the backend
+func (c *CircuitBreakerStorage) record(err error, elapsed time.Duration, probe bool) {
+	// Calls cancelled by their caller say nothing about the backend
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	failed := err != nil || (c.opts.SlowCallDuration > 0 && elapsed > c.opts.SlowCallDuration)
+
+	if probe {
+		if failed {
+			c.open()
+			return
+		}
+		c.successes++
+		if c.successes >= c.opts.HalfOpenSuccesses {
+			c.failures = 0
+			c.setState(CircuitClosed)
+		}
+		return
+	}
+
+	if c.state != CircuitClosed {
+		// A call that started before the circuit opened
+		return
+	}
+	if !failed {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.failures >= c.opts.FailureThreshold {
+		c.open()
+	}
+}
+
+// open opens the circuit
+func (c *CircuitBreakerStorage) open() {
+	c.openedAt = time.Now()
+	c.successes = 0
+	c.setState(CircuitOpen)
+}
+
+// setState changes the state of the circuit and reports the change
+func (c *CircuitBreakerStorage) setState(state CircuitState) {
+	if c.state == state {
+		return
+	}
+	from := c.state
+	c.state = state
+	if c.opts.OnStateChange != nil {
+		c.opts.OnStateChange(from, state)
+	}
+}
+
+// call runs fn against the storage the circuit selects and records the
+// outcome of calls to the backend
+func call[T any](c *CircuitBreakerStorage, fn func(Storage) (T, error)) (T, error) {
+	target, primary, probe := c.acquire()
+	if target == nil {
+		var zero T
+		return zero, ErrCircuitOpen
+	}
+	if !primary {
+		return fn(target)
+	}
+
+	if probe {
+		// Let another call probe the backend once this one is over, even
+		// when it was cancelled or panicked
+		defer c.endProbe()
+	}
+
+	start := time.Now()
+	value, err := fn(target)
+	c.record(err, time.Since(start), probe)
+	return value, err
+}
+
+// endProbe lets the next call probe the backend
+func (c *CircuitBreakerStorage) endProbe() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+}
+
+func (c *CircuitBreakerStorage) GetRequestCount(ctx context.Context, key string) (int64, error) {
+	return call(c, func(s Storage) (int64, error) {
+		return s.GetRequestCount(ctx, key)
+	})
+}
+
+func (c *CircuitBreakerStorage) IncrementRequestCount(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	return call(c, func(s Storage) (int64, error) {
+		return s.IncrementRequestCount(ctx, key, expiration)
+	})
+}
+
+func (c *CircuitBreakerStorage) IncrByWithCost(ctx context.Context, key string, cost int64, expiration time.Duration) (int64, error) {
+	return call(c, func(s Storage) (int64, error) {
+		return s.IncrByWithCost(ctx, key, cost, expiration)
+	})
+}
+
+func (c *CircuitBreakerStorage) CheckAndIncrement(ctx context.Context, key string, cost, limit int64, window, blockDuration time.Duration) (Result, error) {
+	return call(c, func(s Storage) (Result, error) {
+		return s.CheckAndIncrement(ctx, key, cost, limit, window, blockDuration)
+	})
+}
+
+func (c *CircuitBreakerStorage) TakeToken(ctx context.Context, key string, cost int64, rate float64, capacity int64) (Result, error) {
+	return call(c, func(s Storage) (Result, error) {
+		return s.TakeToken(ctx, key, cost, rate, capacity)
+	})
+}
+
+func (c *CircuitBreakerStorage) SlidingWindowLog(ctx context.Context, key string, cost, limit int64, window time.Duration) (Result, error) {
+	return call(c, func(s Storage) (Result, error) {
+		return s.SlidingWindowLog(ctx, key, cost, limit, window)
+	})
+}
+
+func (c *CircuitBreakerStorage) SlidingWindowCounter(ctx context.Context, key string, cost, limit int64, window time.Duration) (Result, error) {
+	return call(c, func(s Storage) (Result, error) {
+		return s.SlidingWindowCounter(ctx, key, cost, limit, window)
+	})
+}
+
+func (c *CircuitBreakerStorage) IncrementQuotas(ctx context.Context, key string, cost int64, quotas []Quota) (Result, error) {
+	return call(c, func(s Storage) (Result, error) {
+		return s.IncrementQuotas(ctx, key, cost, quotas)
+	})
+}
+
+func (c *CircuitBreakerStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	return call(c, func(s Storage) (bool, error) {
+		return s.IsBlocked(ctx, key)
+	})
+}
+
+func (c *CircuitBreakerStorage) Block(ctx context.Context, key string, duration time.Duration) error {
+	_, err := call(c, func(s Storage) (struct{}, error) {
+		return struct{}{}, s.Block(ctx, key, duration)
+	})
+	return err
+}
+
+func (c *CircuitBreakerStorage) GetBlockTTL(ctx context.Context, key string) (time.Duration, error) {
+	return call(c, func(s Storage) (time.Duration, error) {
+		return s.GetBlockTTL(ctx, key)
+	})
+}
+
+func (c *CircuitBreakerStorage) GetWindowTTL(ctx context.Context, key string) (time.Duration, error) {
+	return call(c, func(s Storage) (time.Duration, error) {
+		return s.GetWindowTTL(ctx, key)
+	})
+}
+
+func (c *CircuitBreakerStorage) Unblock(ctx context.Context, key string) error {
+	_, err := call(c, func(s Storage) (struct{}, error) {
+		return struct{}{}, s.Unblock(ctx, key)
+	})
+	return err
+}
+
+func (c *CircuitBreakerStorage) Reset(ctx context.Context, key string, quotas []Quota) error {
+	_, err := call(c, func(s Storage) (struct{}, error) {
+		return struct{}{}, s.Reset(ctx, key, quotas)
+	})
+	return err
+}
+
+func (c *CircuitBreakerStorage) ListBlocked(ctx context.Context, limit int) ([]BlockedKey, error) {
+	return call(c, func(s Storage) ([]BlockedKey, error) {
+		return s.ListBlocked(ctx, limit)
+	})
+}
+
+// Close closes the backend and the fallback
+func (c *CircuitBreakerStorage) Close() error {
+	err := c.backend.Close()
+	if c.opts.Fallback != nil {
+		err = errors.Join(err, c.opts.Fallback.Close())
+	}
+	return err
+}
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyStorage is a MemoryStorage whose GetRequestCount fails while down,
+// panics while panicking and honours cancelled contexts
+type flakyStorage struct {
+	*MemoryStorage
+	down      atomic.Bool
+	panicking atomic.Bool
+	calls     atomic.Int64
+}
+
+func (f *flakyStorage) GetRequestCount(ctx context.Context, key string) (int64, error) {
+	f.calls.Add(1)
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if f.panicking.Load() {
+		panic("backend panicked")
+	}
+	if f.down.Load() {
+		return 0, errors.New("connection refused")
+	}
+	return f.MemoryStorage.GetRequestCount(ctx, key)
+}
+
+func TestCircuitBreakerStorage(t *testing.T) {
+	ctx := context.Background()
+	backend := &flakyStorage{MemoryStorage: NewMemoryStorage(MemoryOptions{})}
+	fallback := NewMemoryStorage(MemoryOptions{})
+
+	var transitions []string
+	breaker := NewCircuitBreakerStorage(backend, CircuitBreakerOptions{
+		FailureThreshold: 3,
+		OpenDuration:     50 * time.Millisecond,
+		Fallback:         fallback,
+		OnStateChange: func(from, to CircuitState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	})
+	defer breaker.Close()
+
+	_, err := backend.MemoryStorage.IncrementRequestCount(ctx, "key", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Consecutive failures trip the breaker
+	backend.down.Store(true)
+	for i := 0; i < 3; i++ {
+		if _, err := breaker.GetRequestCount(ctx, "key"); err == nil {
+			t.Fatalf("Call %d should fail", i+1)
+		}
+	}
+	if state := breaker.State(); state != CircuitOpen {
+		t.Fatalf("State() = %v, want open", state)
+	}
+
+	// While open, calls go to the fallback without reaching the backend
+	calls := backend.calls.Load()
+	count, err := breaker.GetRequestCount(ctx, "key")
+	if err != nil || count != 0 {
+		t.Errorf("GetRequestCount() = %d, %v, want 0 from the fallback", count, err)
+	}
+	if backend.calls.Load() != calls {
+		t.Error("Open breaker should not call the backend")
+	}
+
+	// A failed probe opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	if _, err := breaker.GetRequestCount(ctx, "key"); err == nil {
+		t.Error("Failed probe should return the backend error")
+	}
+	if state := breaker.State(); state != CircuitOpen {
+		t.Fatalf("State() = %v, want open after a failed probe", state)
+	}
+
+	// A successful probe closes it
+	backend.down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	count, err = breaker.GetRequestCount(ctx, "key")
+	if err != nil || count != 1 {
+		t.Errorf("GetRequestCount() = %d, %v, want 1 from the backend", count, err)
+	}
+	if state := breaker.State(); state != CircuitClosed {
+		t.Errorf("State() = %v, want closed", state)
+	}
+
+	want := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Errorf("transitions = %v, want %v", transitions, want)
+			break
+		}
+	}
+}
+
+func TestCircuitBreakerStorageWithoutFallback(t *testing.T) {
+	backend := &flakyStorage{MemoryStorage: NewMemoryStorage(MemoryOptions{})}
+	breaker := NewCircuitBreakerStorage(backend, CircuitBreakerOptions{FailureThreshold: 1})
+	defer breaker.Close()
+
+	backend.down.Store(true)
+	breaker.GetRequestCount(context.Background(), "key")
+
+	if _, err := breaker.GetRequestCount(context.Background(), "key"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("GetRequestCount() error = %v, want %v", err, ErrCircuitOpen)
+	}
+}
+
+func TestCircuitBreakerStorageSlowCalls(t *testing.T) {
+	backend := slowStorage{MemoryStorage: NewMemoryStorage(MemoryOptions{}), delay: 20 * time.Millisecond}
+	breaker := NewCircuitBreakerStorage(backend, CircuitBreakerOptions{
+		FailureThreshold: 2,
+		SlowCallDuration: 5 * time.Millisecond,
+	})
+	defer breaker.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := breaker.CheckAndIncrement(context.Background(), "key", 1, 10, time.Second, 0); err != nil {
+			t.Fatalf("Slow call %d should succeed, got %v", i+1, err)
+		}
+	}
+	if state := breaker.State(); state != CircuitOpen {
+		t.Errorf("State() = %v, want open after slow calls", state)
+	}
+}
+
+func TestCircuitBreakerStorageIgnoresCancelledCalls(t *testing.T) {
+	backend := &flakyStorage{MemoryStorage: NewMemoryStorage(MemoryOptions{})}
+	breaker := NewCircuitBreakerStorage(backend, CircuitBreakerOptions{
+		FailureThreshold: 2,
+		OpenDuration:     20 * time.Millisecond,
+	})
+	defer breaker.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A cancelled call doesn't reset the consecutive failures
+	backend.down.Store(true)
+	breaker.GetRequestCount(context.Background(), "key")
+	breaker.GetRequestCount(cancelled, "key")
+	breaker.GetRequestCount(context.Background(), "key")
+	if state := breaker.State(); state != CircuitOpen {
+		t.Fatalf("State() = %v, want open", state)
+	}
+
+	// Nor does a cancelled probe close the circuit
+	time.Sleep(30 * time.Millisecond)
+	breaker.GetRequestCount(cancelled, "key")
+	if state := breaker.State(); state != CircuitHalfOpen {
+		t.Fatalf("State() = %v, want half-open after a cancelled probe", state)
+	}
+
+	// The next call probes the backend again
+	calls := backend.calls.Load()
+	breaker.GetRequestCount(context.Background(), "key")
+	if backend.calls.Load() != calls+1 {
+		t.Error("The call after a cancelled probe should probe the backend")
+	}
+	if state := breaker.State(); state != CircuitOpen {
+		t.Errorf("State() = %v, want open after a failed probe", state)
+	}
+}
+
+func TestCircuitBreakerStoragePanickingProbe(t *testing.T) {
+	backend := &flakyStorage{MemoryStorage: NewMemoryStorage(MemoryOptions{})}
+	breaker := NewCircuitBreakerStorage(backend, CircuitBreakerOptions{
+		FailureThreshold: 1,
+		OpenDuration:     20 * time.Millisecond,
+	})
+	defer breaker.Close()
+
+	backend.down.Store(true)
+	breaker.GetRequestCount(context.Background(), "key")
+	time.Sleep(30 * time.Millisecond)
+
+	backend.panicking.Store(true)
+	func() {
+		defer func() { recover() }()
+		breaker.GetRequestCount(context.Background(), "key")
+	}()
+
+	// Another call gets to probe the backend
+	backend.panicking.Store(false)
+	backend.down.Store(false)
+	if _, err := breaker.GetRequestCount(context.Background(), "key"); err != nil {
+		t.Fatalf("GetRequestCount() error = %v, want a successful probe", err)
+	}
+	if state := breaker.State(); state != CircuitClosed {
+		t.Errorf("State() = %v, want closed", state)
+	}
+}
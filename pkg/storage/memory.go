@@ -0,0 +1,599 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMemoryShards          = 32
+	defaultMemoryCleanupInterval = time.Minute
+)
+
+// MemoryOptions configures a MemoryStorage
+type MemoryOptions struct {
+	// Shards is the number of independently locked partitions keys are
+	// spread over (default: 32)
+	Shards int
+
+	// CleanupInterval is how often the janitor removes expired keys
+	// (default: one minute). A negative value disables the janitor.
+	CleanupInterval time.Duration
+
+	// MaxKeys bounds the number of keys kept in memory. When the bound is
+	// reached the least recently used keys are evicted, skipping blocked keys
+	// so that eviction never lifts a block; while every key is blocked the
+	// bound is exceeded until blocks expire. Zero means unbounded.
+	MaxKeys int
+}
+
+// MemoryStorage implements Storage in process memory. It is meant for
+// single-instance services that do not need limits shared through Redis.
+type MemoryStorage struct {
+	shards []*memoryShard
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type memoryShard struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	lru     *list.List // most recently used keys at the front
+	maxKeys int
+}
+
+// memoryEntry holds every piece of state kept for a key, so that operations
+// touching several counters of the same key only need one lock
+type memoryEntry struct {
+	element *list.Element
+
+	count        counter
+	quotas       map[quotaID]counter // additional quota counters
+	bucket       tokenBucket
+	log          []time.Time
+	window       slidingWindow
+	blockedUntil time.Time
+
+	// expiresAt is when every piece of state above has expired
+	expiresAt time.Time
+}
+
+type counter struct {
+	count      int64
+	expiration time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type slidingWindow struct {
+	index    int64
+	current  int64
+	previous int64
+}
+
+// NewMemoryStorage creates a new in-memory storage instance and starts its
+// janitor. Call Close to stop the janitor.
+func NewMemoryStorage(opts MemoryOptions) *MemoryStorage {
+	shards := opts.Shards
+	if shards <= 0 {
+		shards = defaultMemoryShards
+	}
+
+	// Spread the key bound over the shards, rounding up
+	maxKeys := 0
+	if opts.MaxKeys > 0 {
+		maxKeys = (opts.MaxKeys + shards - 1) / shards
+	}
+
+	m := &MemoryStorage{
+		shards: make([]*memoryShard, shards),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for i := range m.shards {
+		m.shards[i] = &memoryShard{
+			entries: make(map[string]*memoryEntry),
+			lru:     list.New(),
+			maxKeys: maxKeys,
+		}
+	}
+
+	interval := opts.CleanupInterval
+	if interval == 0 {
+		interval = defaultMemoryCleanupInterval
+	}
+	if interval > 0 {
+		go m.janitor(interval)
+	} else {
+		close(m.done)
+	}
+
+	return m
+}
+
+func (m *MemoryStorage) GetRequestCount(ctx context.Context, key string) (int64, error) {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry := shard.lookup(key)
+	if entry == nil {
+		return 0, nil
+	}
+	return entry.count.value(time.Now()), nil
+}
+
+func (m *MemoryStorage) IncrementRequestCount(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	return m.IncrByWithCost(ctx, key, 1, expiration)
+}
+
+func (m *MemoryStorage) IncrByWithCost(ctx context.Context, key string, cost int64, expiration time.Duration) (int64, error) {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := time.Now()
+	entry := shard.entry(key)
+	entry.count = entry.count.increment(now, expiration, cost)
+	entry.extend(entry.count.expiration)
+
+	return entry.count.count, nil
+}
+
+func (m *MemoryStorage) CheckAndIncrement(ctx context.Context, key string, cost, limit int64, window, blockDuration time.Duration) (Result, error) {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := time.Now()
+	entry := shard.entry(key)
+	if now.Before(entry.blockedUntil) {
+		return Result{
+			Allowed:    false,
+			Blocked:    true,
+			WasBlocked: true,
+			ResetAfter: entry.count.ttl(now),
+			BlockTTL:   entry.blockedUntil.Sub(now),
+		}, nil
+	}
+
+	// Denied requests are not counted, so they don't use up the window
+	if count := entry.count.value(now); cost > limit-count {
+		result := Result{ResetAfter: entry.count.ttl(now), Count: count}
+		if blockDuration > 0 {
+			entry.blockedUntil = now.Add(blockDuration)
+			entry.extend(entry.blockedUntil)
+			result.Blocked = true
+			result.BlockTTL = blockDuration
+		}
+		return result, nil
+	}
+
+	entry.count = entry.count.increment(now, window, cost)
+	entry.extend(entry.count.expiration)
+	return Result{
+		Allowed:    true,
+		Remaining:  limit - entry.count.count,
+		ResetAfter: entry.count.ttl(now),
+		Count:      entry.count.count,
+	}, nil
+}
+
+func (m *MemoryStorage) TakeToken(ctx context.Context, key string, cost int64, rate float64, capacity int64) (Result, error) {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := time.Now()
+	entry := shard.entry(key)
+	bucket := entry.bucket
+	if bucket.last.IsZero() {
+		bucket = tokenBucket{tokens: float64(capacity), last: now}
+	}
+
+	// Refill the bucket for the time elapsed since the last request
+	bucket.tokens += now.Sub(bucket.last).Seconds() * rate
+	if bucket.tokens > float64(capacity) {
+		bucket.tokens = float64(capacity)
+	}
+	bucket.last = now
+
+	allowed := bucket.tokens >= float64(cost)
+	if allowed {
+		bucket.tokens -= float64(cost)
+	}
+	entry.bucket = bucket
+
+	// Keep the bucket until it would be full again
+	var full time.Duration
+	if rate > 0 {
+		full = time.Duration((float64(capacity) - bucket.tokens) / rate * float64(time.Second))
+	}
+	entry.extend(now.Add(full + time.Second))
+
+	return Result{Allowed: allowed, Remaining: int64(bucket.tokens), ResetAfter: full}, nil
+}
+
+func (m *MemoryStorage) SlidingWindowLog(ctx context.Context, key string, cost, limit int64, window time.Duration) (Result, error) {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-window)
+	entry := shard.entry(key)
+
+	// Drop requests that fell out of the trailing window
+	log := entry.log
+	i := 0
+	for i < len(log) && !log[i].After(cutoff) {
+		i++
+	}
+	log = log[i:]
+
+	allowed := cost <= limit-int64(len(log))
+	if allowed {
+		for range cost {
+			log = append(log, now)
+		}
+	}
+	entry.log = log
+	entry.extend(now.Add(window))
+
+	// A slot frees up when the oldest request leaves the window
+	reset := window
+	if len(log) > 0 {
+		reset = log[0].Add(window).Sub(now)
+	}
+
+	return Result{Allowed: allowed, Remaining: max(0, limit-int64(len(log))), ResetAfter: reset}, nil
+}
+
+func (m *MemoryStorage) SlidingWindowCounter(ctx context.Context, key string, cost, limit int64, window time.Duration) (Result, error) {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	index := now / int64(window)
+	elapsed := float64(now%int64(window)) / float64(window)
+
+	entry := shard.entry(key)
+	current := entry.window
+	switch {
+	case current.index == index:
+	case current.index == index-1:
+		current = slidingWindow{index: index, previous: current.current}
+	default:
+		current = slidingWindow{index: index}
+	}
+
+	estimate := float64(current.previous)*(1-elapsed) + float64(current.current)
+	allowed := estimate+float64(cost) <= float64(limit)
+	if allowed {
+		current.current += cost
+		estimate += float64(cost)
+	}
+	entry.window = current
+
+	// The current window is still read as the previous one during the next
+	entry.extend(time.Unix(0, (index+2)*int64(window)))
+
+	return Result{
+		Allowed:    allowed,
+		Remaining:  max(0, int64(float64(limit)-estimate)),
+		ResetAfter: time.Duration((index+1)*int64(window) - now),
+	}, nil
+}
+
+func (m *MemoryStorage) IncrementQuotas(ctx context.Context, key string, cost int64, quotas []Quota) (Result, error) {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := time.Now()
+	entry := shard.entry(key)
+	for i, quota := range quotas {
+		c := entry.quota(i, quota)
+		if cost > quota.Max-c.value(now) {
+			return Result{Allowed: false, Quota: i, ResetAfter: c.ttl(now)}, nil
+		}
+	}
+
+	result := Result{Allowed: true, Remaining: -1}
+	for i, quota := range quotas {
+		c := entry.quota(i, quota).increment(now, quota.Window, cost)
+		entry.setQuota(i, quota, c)
+		entry.extend(c.expiration)
+
+		if result.Remaining < 0 || quota.Max-c.count < result.Remaining {
+			result.Quota = i
+			result.Remaining = quota.Max - c.count
+			result.ResetAfter = c.ttl(now)
+		}
+	}
+
+	return result, nil
+}
+
+func (m *MemoryStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry := shard.lookup(key)
+	return entry != nil && time.Now().Before(entry.blockedUntil), nil
+}
+
+func (m *MemoryStorage) Block(ctx context.Context, key string, duration time.Duration) error {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry := shard.entry(key)
+	entry.blockedUntil = time.Now().Add(duration)
+	entry.extend(entry.blockedUntil)
+	return nil
+}
+
+func (m *MemoryStorage) GetBlockTTL(ctx context.Context, key string) (time.Duration, error) {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry := shard.lookup(key)
+	if entry == nil {
+		return 0, nil
+	}
+	return max(0, time.Until(entry.blockedUntil)), nil
+}
+
+func (m *MemoryStorage) GetWindowTTL(ctx context.Context, key string) (time.Duration, error) {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry := shard.lookup(key)
+	if entry == nil {
+		return 0, nil
+	}
+	return entry.count.ttl(time.Now()), nil
+}
+
+func (m *MemoryStorage) Unblock(ctx context.Context, key string) error {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if entry := shard.lookup(key); entry != nil {
+		entry.blockedUntil = time.Time{}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) Reset(ctx context.Context, key string, quotas []Quota) error {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry := shard.lookup(key)
+	if entry == nil {
+		return nil
+	}
+	if !time.Now().Before(entry.blockedUntil) {
+		shard.remove(key, entry)
+		return nil
+	}
+
+	// Keep the block only
+	entry.count = counter{}
+	entry.quotas = nil
+	entry.bucket = tokenBucket{}
+	entry.log = nil
+	entry.window = slidingWindow{}
+	return nil
+}
+
+func (m *MemoryStorage) ListBlocked(ctx context.Context, limit int) ([]BlockedKey, error) {
+	now := time.Now()
+	var blocked []BlockedKey
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+		for key, entry := range shard.entries {
+			if limit > 0 && len(blocked) >= limit {
+				break
+			}
+			if now.Before(entry.blockedUntil) {
+				blocked = append(blocked, BlockedKey{Key: key, TTL: entry.blockedUntil.Sub(now)})
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return blocked, nil
+}
+
+// Close stops the janitor and discards every key
+func (m *MemoryStorage) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+		<-m.done
+
+		for _, shard := range m.shards {
+			shard.mu.Lock()
+			shard.entries = make(map[string]*memoryEntry)
+			shard.lru.Init()
+			shard.mu.Unlock()
+		}
+	})
+	return nil
+}
+
+// Len returns the number of keys currently held, including expired keys the
+// janitor has not removed yet
+func (m *MemoryStorage) Len() int {
+	n := 0
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+		n += len(shard.entries)
+		shard.mu.Unlock()
+	}
+	return n
+}
+
+// janitor periodically removes expired keys until Close is called
+func (m *MemoryStorage) janitor(interval time.Duration) {
+	defer close(m.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.deleteExpired(time.Now())
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// deleteExpired removes every key whose state has completely expired
+func (m *MemoryStorage) deleteExpired(now time.Time) {
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+		for key, entry := range shard.entries {
+			if !now.Before(entry.expiresAt) {
+				shard.remove(key, entry)
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// shard returns the shard responsible for a key
+func (m *MemoryStorage) shard(key string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return m.shards[h.Sum32()%uint32(len(m.shards))]
+}
+
+// lookup returns the entry for a key without creating it. The shard lock must
+// be held.
+func (s *memoryShard) lookup(key string) *memoryEntry {
+	entry, exists := s.entries[key]
+	if !exists {
+		return nil
+	}
+	s.lru.MoveToFront(entry.element)
+	return entry
+}
+
+// entry returns the entry for a key, creating it and evicting the least
+// recently used key if the shard is full. The shard lock must be held.
+func (s *memoryShard) entry(key string) *memoryEntry {
+	if entry := s.lookup(key); entry != nil {
+		return entry
+	}
+
+	// Evict before adding the key, so that the new key is never evicted
+	if s.maxKeys > 0 && len(s.entries) >= s.maxKeys {
+		s.evict(time.Now())
+	}
+
+	entry := &memoryEntry{element: s.lru.PushFront(key)}
+	s.entries[key] = entry
+	return entry
+}
+
+// evict removes the least recently used key that is not blocked. Evicting a
+// blocked key would lift its block, so when every key is blocked none is
+// evicted and the shard stays over its bound until the blocks expire. The
+// shard lock must be held.
+func (s *memoryShard) evict(now time.Time) {
+	for e := s.lru.Back(); e != nil; e = e.Prev() {
+		key := e.Value.(string)
+		if entry := s.entries[key]; !now.Before(entry.blockedUntil) {
+			s.remove(key, entry)
+			return
+		}
+	}
+}
+
+// remove deletes a key from the shard. The shard lock must be held.
+func (s *memoryShard) remove(key string, entry *memoryEntry) {
+	s.lru.Remove(entry.element)
+	delete(s.entries, key)
+}
+
+// extend makes sure the entry is kept at least until t
+func (e *memoryEntry) extend(t time.Time) {
+	if t.After(e.expiresAt) {
+		e.expiresAt = t
+	}
+}
+
+// quotaID identifies the counter of an additional quota. Quotas are told
+// apart by position, so that two with the same window keep their own
+// counters, and by window, so that reordering them doesn't mix counters.
+type quotaID struct {
+	index  int
+	window time.Duration
+}
+
+// quota returns the counter of the i-th quota. The first quota shares its
+// counter with IncrementRequestCount.
+func (e *memoryEntry) quota(i int, quota Quota) counter {
+	if i == 0 {
+		return e.count
+	}
+	return e.quotas[quotaID{index: i, window: quota.Window}]
+}
+
+// setQuota stores the counter of the i-th quota
+func (e *memoryEntry) setQuota(i int, quota Quota, c counter) {
+	if i == 0 {
+		e.count = c
+		return
+	}
+	if e.quotas == nil {
+		e.quotas = make(map[quotaID]counter)
+	}
+	e.quotas[quotaID{index: i, window: quota.Window}] = c
+}
+
+// value returns the count, or zero once the counter expired
+func (c counter) value(now time.Time) int64 {
+	if now.Before(c.expiration) {
+		return c.count
+	}
+	return 0
+}
+
+// ttl returns the time left in the counter's window
+func (c counter) ttl(now time.Time) time.Duration {
+	return max(0, c.expiration.Sub(now))
+}
+
+// increment adds cost to the counter, starting a new window of the given
+// length if the previous one expired. The count saturates instead of
+// wrapping around to negative values.
+func (c counter) increment(now time.Time, window time.Duration, cost int64) counter {
+	if !now.Before(c.expiration) {
+		c = counter{expiration: now.Add(window)}
+	}
+	if c.count > math.MaxInt64-cost {
+		c.count = math.MaxInt64
+	} else {
+		c.count += cost
+	}
+	return c
+}
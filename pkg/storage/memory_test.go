@@ -0,0 +1,477 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MemoryStorageTestSuite struct {
+	suite.Suite
+	ms  *MemoryStorage
+	ctx context.Context
+}
+
+func (s *MemoryStorageTestSuite) SetupTest() {
+	s.ms = NewMemoryStorage(MemoryOptions{})
+	s.ctx = context.Background()
+}
+
+func (s *MemoryStorageTestSuite) TearDownTest() {
+	s.ms.Close()
+}
+
+func (s *MemoryStorageTestSuite) TestIncrementRequestCount() {
+	key := "test-key"
+
+	count, err := s.ms.GetRequestCount(s.ctx, key)
+	s.Require().NoError(err)
+	s.Equal(int64(0), count)
+
+	for i := int64(1); i <= 3; i++ {
+		count, err = s.ms.IncrementRequestCount(s.ctx, key, 50*time.Millisecond)
+		s.Require().NoError(err)
+		s.Equal(i, count)
+	}
+
+	// The window starts with the first increment and is not extended
+	time.Sleep(60 * time.Millisecond)
+
+	count, err = s.ms.GetRequestCount(s.ctx, key)
+	s.Require().NoError(err)
+	s.Equal(int64(0), count)
+}
+
+func (s *MemoryStorageTestSuite) TestIncrByWithCost() {
+	key := "test-key"
+
+	count, err := s.ms.IncrByWithCost(s.ctx, key, 5, time.Minute)
+	s.Require().NoError(err)
+	s.Equal(int64(5), count)
+
+	count, err = s.ms.IncrByWithCost(s.ctx, key, 3, time.Minute)
+	s.Require().NoError(err)
+	s.Equal(int64(8), count)
+}
+
+func (s *MemoryStorageTestSuite) TestHugeCostsDoNotOverflow() {
+	_, err := s.ms.IncrByWithCost(s.ctx, "counter", 1, time.Minute)
+	s.Require().NoError(err)
+	count, err := s.ms.IncrByWithCost(s.ctx, "counter", math.MaxInt64, time.Minute)
+	s.Require().NoError(err)
+	s.Equal(int64(math.MaxInt64), count)
+
+	result, err := s.ms.CheckAndIncrement(s.ctx, "window", math.MaxInt64, 10, time.Minute, 0)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+	result, err = s.ms.CheckAndIncrement(s.ctx, "window", math.MaxInt64, 10, time.Minute, 0)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+
+	quotas := []Quota{{Max: 10, Window: time.Minute}}
+	_, err = s.ms.IncrementQuotas(s.ctx, "quotas", 1, quotas)
+	s.Require().NoError(err)
+	result, err = s.ms.IncrementQuotas(s.ctx, "quotas", math.MaxInt64, quotas)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+
+	result, err = s.ms.SlidingWindowLog(s.ctx, "log", math.MaxInt64, 10, time.Minute)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+}
+
+func (s *MemoryStorageTestSuite) TestCostDeniedWhenOverRemaining() {
+	key := "test-key"
+
+	result, err := s.ms.CheckAndIncrement(s.ctx, key, 4, 5, time.Second, 0)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+	s.Equal(int64(1), result.Remaining)
+
+	// One unit is left, so a request costing two is denied
+	result, err = s.ms.CheckAndIncrement(s.ctx, key, 2, 5, time.Second, 0)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+
+	result, err = s.ms.TakeToken(s.ctx, "bucket", 3, 1, 3)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+	result, err = s.ms.TakeToken(s.ctx, "bucket", 1, 1, 3)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+
+	result, err = s.ms.SlidingWindowLog(s.ctx, "log", 2, 3, time.Second)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+	s.Equal(int64(1), result.Remaining)
+	result, err = s.ms.SlidingWindowLog(s.ctx, "log", 2, 3, time.Second)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+
+	result, err = s.ms.SlidingWindowCounter(s.ctx, "counter", 3, 3, time.Minute)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+	result, err = s.ms.SlidingWindowCounter(s.ctx, "counter", 1, 3, time.Minute)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+
+	quotas := []Quota{{Max: 10, Window: time.Second}, {Max: 4, Window: time.Minute}}
+	result, err = s.ms.IncrementQuotas(s.ctx, "quotas", 3, quotas)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+	result, err = s.ms.IncrementQuotas(s.ctx, "quotas", 2, quotas)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+	s.Equal(1, result.Quota)
+}
+
+func (s *MemoryStorageTestSuite) TestCheckAndIncrement() {
+	key := "test-key"
+
+	for i := int64(1); i >= 0; i-- {
+		result, err := s.ms.CheckAndIncrement(s.ctx, key, 1, 2, time.Second, time.Minute)
+		s.Require().NoError(err)
+		s.True(result.Allowed)
+		s.Equal(i, result.Remaining)
+	}
+
+	result, err := s.ms.CheckAndIncrement(s.ctx, key, 1, 2, time.Second, time.Minute)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+	s.True(result.Blocked)
+	s.False(result.WasBlocked)
+	s.Equal(int64(2), result.Count)
+
+	blocked, err := s.ms.IsBlocked(s.ctx, key)
+	s.Require().NoError(err)
+	s.True(blocked)
+
+	result, err = s.ms.CheckAndIncrement(s.ctx, key, 1, 2, time.Second, time.Minute)
+	s.Require().NoError(err)
+	s.True(result.WasBlocked)
+}
+
+func (s *MemoryStorageTestSuite) TestCheckAndIncrementDeniedCostIsNotCounted() {
+	key := "test-key"
+
+	result, err := s.ms.CheckAndIncrement(s.ctx, key, 5, 2, time.Second, 0)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+
+	result, err = s.ms.CheckAndIncrement(s.ctx, key, 2, 2, time.Second, 0)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+	s.Equal(int64(2), result.Count)
+}
+
+func (s *MemoryStorageTestSuite) TestTakeToken() {
+	key := "test-key"
+
+	for i := 0; i < 3; i++ {
+		result, err := s.ms.TakeToken(s.ctx, key, 1, 1, 3)
+		s.Require().NoError(err)
+		s.True(result.Allowed)
+	}
+
+	result, err := s.ms.TakeToken(s.ctx, key, 1, 1, 3)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+}
+
+func (s *MemoryStorageTestSuite) TestSlidingWindowLog() {
+	key := "test-key"
+	window := 50 * time.Millisecond
+
+	for i := 0; i < 2; i++ {
+		result, err := s.ms.SlidingWindowLog(s.ctx, key, 1, 2, window)
+		s.Require().NoError(err)
+		s.True(result.Allowed)
+	}
+
+	result, err := s.ms.SlidingWindowLog(s.ctx, key, 1, 2, window)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+
+	time.Sleep(window + 10*time.Millisecond)
+
+	result, err = s.ms.SlidingWindowLog(s.ctx, key, 1, 2, window)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+}
+
+func (s *MemoryStorageTestSuite) TestSlidingWindowCounter() {
+	key := "test-key"
+
+	for i := 0; i < 3; i++ {
+		result, err := s.ms.SlidingWindowCounter(s.ctx, key, 1, 3, time.Minute)
+		s.Require().NoError(err)
+		s.True(result.Allowed)
+	}
+
+	result, err := s.ms.SlidingWindowCounter(s.ctx, key, 1, 3, time.Minute)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+}
+
+func (s *MemoryStorageTestSuite) TestIncrementQuotas() {
+	key := "test-key"
+	quotas := []Quota{
+		{Max: 5, Window: time.Second},
+		{Max: 2, Window: time.Minute},
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := s.ms.IncrementQuotas(s.ctx, key, 1, quotas)
+		s.Require().NoError(err)
+		s.True(result.Allowed)
+	}
+
+	result, err := s.ms.IncrementQuotas(s.ctx, key, 1, quotas)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+	s.Equal(1, result.Quota)
+
+	// The first quota shares its counter with IncrementRequestCount
+	count, err := s.ms.GetRequestCount(s.ctx, key)
+	s.Require().NoError(err)
+	s.Equal(int64(2), count)
+}
+
+func (s *MemoryStorageTestSuite) TestIncrementQuotasWithSameWindow() {
+	key := "test-key"
+	quotas := []Quota{
+		{Max: 10, Window: time.Second},
+		{Max: 3, Window: time.Minute},
+		{Max: 5, Window: time.Minute},
+	}
+
+	// Each quota counts every request once
+	for i := 0; i < 3; i++ {
+		result, err := s.ms.IncrementQuotas(s.ctx, key, 1, quotas)
+		s.Require().NoError(err)
+		s.True(result.Allowed)
+	}
+
+	result, err := s.ms.IncrementQuotas(s.ctx, key, 1, quotas)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+	s.Equal(1, result.Quota)
+}
+
+func (s *MemoryStorageTestSuite) TestBlock() {
+	key := "test-key"
+
+	blocked, err := s.ms.IsBlocked(s.ctx, key)
+	s.Require().NoError(err)
+	s.False(blocked)
+
+	s.Require().NoError(s.ms.Block(s.ctx, key, 50*time.Millisecond))
+
+	blocked, err = s.ms.IsBlocked(s.ctx, key)
+	s.Require().NoError(err)
+	s.True(blocked)
+
+	time.Sleep(60 * time.Millisecond)
+
+	blocked, err = s.ms.IsBlocked(s.ctx, key)
+	s.Require().NoError(err)
+	s.False(blocked)
+}
+
+func (s *MemoryStorageTestSuite) TestGetBlockTTL() {
+	key := "test-key"
+
+	ttl, err := s.ms.GetBlockTTL(s.ctx, key)
+	s.Require().NoError(err)
+	s.Zero(ttl)
+
+	s.Require().NoError(s.ms.Block(s.ctx, key, time.Minute))
+
+	ttl, err = s.ms.GetBlockTTL(s.ctx, key)
+	s.Require().NoError(err)
+	s.InDelta(time.Minute, ttl, float64(time.Second))
+}
+
+func (s *MemoryStorageTestSuite) TestGetWindowTTL() {
+	key := "test-key"
+
+	ttl, err := s.ms.GetWindowTTL(s.ctx, key)
+	s.Require().NoError(err)
+	s.Zero(ttl)
+
+	_, err = s.ms.IncrementRequestCount(s.ctx, key, time.Minute)
+	s.Require().NoError(err)
+
+	ttl, err = s.ms.GetWindowTTL(s.ctx, key)
+	s.Require().NoError(err)
+	s.InDelta(time.Minute, ttl, float64(time.Second))
+}
+
+func (s *MemoryStorageTestSuite) TestUnblock() {
+	key := "ip:10.0.0.1"
+	s.Require().NoError(s.ms.Block(s.ctx, key, time.Minute))
+
+	s.Require().NoError(s.ms.Unblock(s.ctx, key))
+	blocked, err := s.ms.IsBlocked(s.ctx, key)
+	s.Require().NoError(err)
+	s.False(blocked)
+
+	s.NoError(s.ms.Unblock(s.ctx, "ip:10.0.0.2"))
+}
+
+func (s *MemoryStorageTestSuite) TestReset() {
+	key := "ip:10.0.0.1"
+
+	_, err := s.ms.IncrementRequestCount(s.ctx, key, time.Minute)
+	s.Require().NoError(err)
+	_, err = s.ms.TakeToken(s.ctx, key, 5, 1, 5)
+	s.Require().NoError(err)
+	s.Require().NoError(s.ms.Block(s.ctx, key, time.Minute))
+
+	s.Require().NoError(s.ms.Reset(s.ctx, key, nil))
+
+	count, err := s.ms.GetRequestCount(s.ctx, key)
+	s.Require().NoError(err)
+	s.Zero(count)
+	result, err := s.ms.TakeToken(s.ctx, key, 5, 1, 5)
+	s.Require().NoError(err)
+	s.True(result.Allowed, "the token bucket should be full again")
+
+	blocked, err := s.ms.IsBlocked(s.ctx, key)
+	s.Require().NoError(err)
+	s.True(blocked, "the block should be kept")
+
+	// Keys without a block are removed
+	s.Require().NoError(s.ms.Unblock(s.ctx, key))
+	s.Require().NoError(s.ms.Reset(s.ctx, key, nil))
+	s.Zero(s.ms.Len())
+}
+
+func (s *MemoryStorageTestSuite) TestListBlocked() {
+	s.Require().NoError(s.ms.Block(s.ctx, "ip:10.0.0.1", time.Minute))
+	s.Require().NoError(s.ms.Block(s.ctx, "token:abc", time.Millisecond))
+	_, err := s.ms.IncrementRequestCount(s.ctx, "ip:10.0.0.2", time.Minute)
+	s.Require().NoError(err)
+	time.Sleep(5 * time.Millisecond)
+
+	blocked, err := s.ms.ListBlocked(s.ctx, 0)
+	s.Require().NoError(err)
+	s.Require().Len(blocked, 1)
+	s.Equal("ip:10.0.0.1", blocked[0].Key)
+	s.InDelta(time.Minute, blocked[0].TTL, float64(time.Second))
+}
+
+func (s *MemoryStorageTestSuite) TestJanitorRemovesExpiredKeys() {
+	ms := NewMemoryStorage(MemoryOptions{CleanupInterval: 10 * time.Millisecond})
+	defer ms.Close()
+
+	_, err := ms.IncrementRequestCount(s.ctx, "short", 20*time.Millisecond)
+	s.Require().NoError(err)
+	s.Require().NoError(ms.Block(s.ctx, "blocked", time.Minute))
+	s.Equal(2, ms.Len())
+
+	// Only the expired counter is removed
+	s.Eventually(func() bool { return ms.Len() == 1 }, time.Second, 10*time.Millisecond)
+
+	blocked, err := ms.IsBlocked(s.ctx, "blocked")
+	s.Require().NoError(err)
+	s.True(blocked)
+}
+
+func (s *MemoryStorageTestSuite) TestMaxKeysEvictsLeastRecentlyUsed() {
+	ms := NewMemoryStorage(MemoryOptions{Shards: 1, MaxKeys: 2, CleanupInterval: -1})
+	defer ms.Close()
+
+	_, err := ms.IncrementRequestCount(s.ctx, "a", time.Minute)
+	s.Require().NoError(err)
+	_, err = ms.IncrementRequestCount(s.ctx, "b", time.Minute)
+	s.Require().NoError(err)
+
+	// Using "a" again makes "b" the least recently used key
+	_, err = ms.IncrementRequestCount(s.ctx, "a", time.Minute)
+	s.Require().NoError(err)
+	_, err = ms.IncrementRequestCount(s.ctx, "c", time.Minute)
+	s.Require().NoError(err)
+
+	s.Equal(2, ms.Len())
+
+	count, err := ms.GetRequestCount(s.ctx, "a")
+	s.Require().NoError(err)
+	s.Equal(int64(2), count)
+
+	count, err = ms.GetRequestCount(s.ctx, "b")
+	s.Require().NoError(err)
+	s.Equal(int64(0), count)
+}
+
+func (s *MemoryStorageTestSuite) TestMaxKeysKeepsBlockedKeys() {
+	ms := NewMemoryStorage(MemoryOptions{Shards: 1, MaxKeys: 2, CleanupInterval: -1})
+	defer ms.Close()
+
+	s.Require().NoError(ms.Block(s.ctx, "blocked", time.Minute))
+	_, err := ms.IncrementRequestCount(s.ctx, "a", time.Minute)
+	s.Require().NoError(err)
+
+	// "blocked" is the least recently used key, but "a" is evicted instead
+	_, err = ms.IncrementRequestCount(s.ctx, "b", time.Minute)
+	s.Require().NoError(err)
+
+	blocked, err := ms.IsBlocked(s.ctx, "blocked")
+	s.Require().NoError(err)
+	s.True(blocked)
+
+	count, err := ms.GetRequestCount(s.ctx, "a")
+	s.Require().NoError(err)
+	s.Equal(int64(0), count)
+
+	// When every key is blocked, none is evicted
+	s.Require().NoError(ms.Block(s.ctx, "b", time.Minute))
+	s.Require().NoError(ms.Block(s.ctx, "c", time.Minute))
+	s.Equal(3, ms.Len())
+
+	blocked, err = ms.IsBlocked(s.ctx, "blocked")
+	s.Require().NoError(err)
+	s.True(blocked)
+}
+
+func (s *MemoryStorageTestSuite) TestConcurrentIncrements() {
+	key := "test-key"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				s.ms.IncrementRequestCount(s.ctx, key, time.Minute)
+				s.ms.IsBlocked(s.ctx, fmt.Sprintf("other-%d", j))
+			}
+		}()
+	}
+	wg.Wait()
+
+	count, err := s.ms.GetRequestCount(s.ctx, key)
+	s.Require().NoError(err)
+	s.Equal(int64(1000), count)
+}
+
+func (s *MemoryStorageTestSuite) TestClose() {
+	ms := NewMemoryStorage(MemoryOptions{CleanupInterval: time.Millisecond})
+	_, err := ms.IncrementRequestCount(s.ctx, "key", time.Minute)
+	s.Require().NoError(err)
+
+	s.Require().NoError(ms.Close())
+	s.Equal(0, ms.Len())
+
+	// Closing twice is safe
+	s.Require().NoError(ms.Close())
+}
+
+func TestMemoryStorageTestSuite(t *testing.T) {
+	suite.Run(t, new(MemoryStorageTestSuite))
+}
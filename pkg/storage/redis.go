@@ -2,23 +2,83 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// KeySchemaVersion is the version of the layout of the Redis keys, which is
+// part of every key. It is bumped whenever the layout changes, so that a new
+// release never misreads keys written by an old one; the old keys simply
+// expire.
+const KeySchemaVersion = 1
+
 type RedisStorage struct {
-	client *redis.Client
+	client redis.UniversalClient
+
+	// tracer creates a span for each call, when set
+	tracer trace.Tracer
+
+	// prefix namespaces every key, so that several applications can share a
+	// Redis instance
+	prefix string
+}
+
+// RedisOption configures optional RedisStorage behavior
+type RedisOption func(*RedisStorage)
+
+// WithKeyPrefix prepends prefix to every key, e.g. "rl:checkout:prod:"
+// turns "v1:count:{ip:10.0.0.1}" into "rl:checkout:prod:v1:count:{ip:10.0.0.1}".
+// The prefix must not contain braces, which would change the Redis Cluster
+// hash slot of the keys.
+func WithKeyPrefix(prefix string) RedisOption {
+	return func(r *RedisStorage) {
+		r.prefix = prefix
+	}
 }
 
 // NewRedisStorage creates a new Redis storage instance
-func NewRedisStorage(addr, password string, db int) (*RedisStorage, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       db,
-	})
+func NewRedisStorage(addr, password string, db int, opts ...RedisOption) (*RedisStorage, error) {
+	return NewRedisStorageWithOptions(&redis.UniversalOptions{
+		Addrs:                 []string{addr},
+		Password:              password,
+		DB:                    db,
+		ContextTimeoutEnabled: true,
+	}, opts...)
+}
+
+// NewRedisStorageWithOptions creates a new Redis storage instance for a
+// single node, a Sentinel-managed primary (MasterName set) or a Redis
+// Cluster (several Addrs or IsClusterMode set)
+func NewRedisStorageWithOptions(redisOpts *redis.UniversalOptions, opts ...RedisOption) (*RedisStorage, error) {
+	client := redis.NewUniversalClient(redisOpts)
+
+	store, err := NewRedisStorageWithClient(client, opts...)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// NewRedisStorageWithClient creates a new Redis storage instance on top of an
+// existing client. Closing the storage closes the client.
+func NewRedisStorageWithClient(client redis.UniversalClient, opts ...RedisOption) (*RedisStorage, error) {
+	store := &RedisStorage{client: client}
+	for _, opt := range opts {
+		opt(store)
+	}
+	if strings.ContainsAny(store.prefix, "{}") {
+		return nil, fmt.Errorf("invalid key prefix %q: braces would change the Redis Cluster hash slot", store.prefix)
+	}
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -28,11 +88,14 @@ func NewRedisStorage(addr, password string, db int) (*RedisStorage, error) {
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
-	return &RedisStorage{client: client}, nil
+	return store, nil
 }
 
-func (r *RedisStorage) GetRequestCount(ctx context.Context, key string) (int64, error) {
-	count, err := r.client.Get(ctx, fmt.Sprintf("count:%s", key)).Int64()
+func (r *RedisStorage) GetRequestCount(ctx context.Context, key string) (count int64, err error) {
+	ctx, span := r.startSpan(ctx, "GetRequestCount")
+	defer func() { endSpan(span, err, attribute.Int64(attrCount, count)) }()
+
+	count, err = r.client.Get(ctx, r.countKey(key)).Int64()
 	if err == redis.Nil {
 		return 0, nil
 	}
@@ -40,28 +103,318 @@ func (r *RedisStorage) GetRequestCount(ctx context.Context, key string) (int64,
 }
 
 func (r *RedisStorage) IncrementRequestCount(ctx context.Context, key string, expiration time.Duration) (int64, error) {
-	countKey := fmt.Sprintf("count:%s", key)
-	pipe := r.client.Pipeline()
-	incr := pipe.Incr(ctx, countKey)
-	pipe.Expire(ctx, countKey, expiration)
-	
-	_, err := pipe.Exec(ctx)
+	return r.IncrByWithCost(ctx, key, 1, expiration)
+}
+
+func (r *RedisStorage) IncrByWithCost(ctx context.Context, key string, cost int64, expiration time.Duration) (count int64, err error) {
+	ctx, span := r.startSpan(ctx, "IncrByWithCost", attribute.Int64(attrCost, cost))
+	defer func() { endSpan(span, err, attribute.Int64(attrCount, count)) }()
+
+	return incrementScript.Run(ctx, r.client, []string{r.countKey(key)},
+		expiration.Milliseconds(), cost).Int64()
+}
+
+func (r *RedisStorage) CheckAndIncrement(ctx context.Context, key string, cost, limit int64, window, blockDuration time.Duration) (result Result, err error) {
+	ctx, span := r.startSpan(ctx, "CheckAndIncrement", attribute.Int64(attrCost, cost), attribute.Int64(attrLimit, limit))
+	defer func() { endSpan(span, err, resultAttributes(result)...) }()
+
+	keys := []string{r.countKey(key), r.blockedKey(key)}
+	res, err := checkAndIncrementScript.Run(ctx, r.client, keys,
+		limit, window.Milliseconds(), blockDuration.Milliseconds(), cost).Int64Slice()
 	if err != nil {
-		return 0, err
+		return Result{}, err
+	}
+
+	result = Result{
+		Allowed:    res[0] == 1,
+		Blocked:    res[1] == 1,
+		WasBlocked: res[1] == 1 && res[2] == 0,
+		Count:      res[2],
+		ResetAfter: milliseconds(res[3]),
+		BlockTTL:   milliseconds(res[4]),
 	}
-	
-	return incr.Val(), nil
+	if result.Allowed {
+		result.Remaining = limit - res[2]
+	}
+	return result, nil
 }
 
-func (r *RedisStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
-	exists, err := r.client.Exists(ctx, fmt.Sprintf("blocked:%s", key)).Result()
+func (r *RedisStorage) TakeToken(ctx context.Context, key string, cost int64, rate float64, capacity int64) (result Result, err error) {
+	ctx, span := r.startSpan(ctx, "TakeToken", attribute.Int64(attrCost, cost), attribute.Int64(attrLimit, capacity))
+	defer func() { endSpan(span, err, resultAttributes(result)...) }()
+
+	res, err := tokenBucketScript.Run(ctx, r.client, []string{r.bucketKey(key)},
+		rate, capacity, time.Now().UnixMilli(), cost).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{Allowed: res[0] == 1, Remaining: res[1], ResetAfter: milliseconds(res[2])}, nil
+}
+
+func (r *RedisStorage) SlidingWindowLog(ctx context.Context, key string, cost, limit int64, window time.Duration) (result Result, err error) {
+	ctx, span := r.startSpan(ctx, "SlidingWindowLog", attribute.Int64(attrCost, cost), attribute.Int64(attrLimit, limit))
+	defer func() { endSpan(span, err, resultAttributes(result)...) }()
+
+	now := time.Now()
+	member := fmt.Sprintf("%d-%d", now.UnixNano(), rand.Uint64())
+	res, err := slidingWindowLogScript.Run(ctx, r.client, []string{r.logKey(key)},
+		limit, window.Milliseconds(), now.UnixMilli(), member, cost).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{Allowed: res[0] == 1, Remaining: res[1], ResetAfter: milliseconds(res[2])}, nil
+}
+
+func (r *RedisStorage) SlidingWindowCounter(ctx context.Context, key string, cost, limit int64, window time.Duration) (result Result, err error) {
+	ctx, span := r.startSpan(ctx, "SlidingWindowCounter", attribute.Int64(attrCost, cost), attribute.Int64(attrLimit, limit))
+	defer func() { endSpan(span, err, resultAttributes(result)...) }()
+
+	now := time.Now().UnixMilli()
+	size := window.Milliseconds()
+	if size <= 0 {
+		return Result{}, fmt.Errorf("sliding window must be at least 1ms, got %v", window)
+	}
+	current := now / size
+	keys := []string{r.windowKey(key, current), r.windowKey(key, current-1)}
+
+	res, err := slidingWindowCounterScript.Run(ctx, r.client, keys, limit, size, now%size, cost).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{Allowed: res[0] == 1, Remaining: res[1], ResetAfter: milliseconds(res[2])}, nil
+}
+
+func (r *RedisStorage) IncrementQuotas(ctx context.Context, key string, cost int64, quotas []Quota) (result Result, err error) {
+	ctx, span := r.startSpan(ctx, "IncrementQuotas", attribute.Int64(attrCost, cost), attribute.Int(attrQuotas, len(quotas)))
+	defer func() { endSpan(span, err, resultAttributes(result)...) }()
+
+	keys := make([]string, len(quotas))
+	args := make([]interface{}, 0, len(quotas)*2+1)
+	args = append(args, cost)
+	for i, quota := range quotas {
+		keys[i] = r.quotaKey(key, i, quota)
+		args = append(args, quota.Max, quota.Window.Milliseconds())
+	}
+
+	res, err := quotasScript.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Allowed:    res[0] == 1,
+		Quota:      int(res[1]),
+		Remaining:  res[2],
+		ResetAfter: milliseconds(res[3]),
+	}, nil
+}
+
+func (r *RedisStorage) IsBlocked(ctx context.Context, key string) (blocked bool, err error) {
+	ctx, span := r.startSpan(ctx, "IsBlocked")
+	defer func() { endSpan(span, err, attribute.Bool(attrBlocked, blocked)) }()
+
+	exists, err := r.client.Exists(ctx, r.blockedKey(key)).Result()
 	return exists == 1, err
 }
 
-func (r *RedisStorage) Block(ctx context.Context, key string, duration time.Duration) error {
-	return r.client.Set(ctx, fmt.Sprintf("blocked:%s", key), 1, duration).Err()
+func (r *RedisStorage) Block(ctx context.Context, key string, duration time.Duration) (err error) {
+	ctx, span := r.startSpan(ctx, "Block", attribute.Int64(attrDuration, duration.Milliseconds()))
+	defer func() { endSpan(span, err) }()
+
+	return r.client.Set(ctx, r.blockedKey(key), 1, duration).Err()
+}
+
+func (r *RedisStorage) GetBlockTTL(ctx context.Context, key string) (ttl time.Duration, err error) {
+	ctx, span := r.startSpan(ctx, "GetBlockTTL")
+	defer func() { endSpan(span, err, attribute.Int64(attrTTL, ttl.Milliseconds())) }()
+
+	return r.ttl(ctx, r.blockedKey(key))
+}
+
+func (r *RedisStorage) GetWindowTTL(ctx context.Context, key string) (ttl time.Duration, err error) {
+	ctx, span := r.startSpan(ctx, "GetWindowTTL")
+	defer func() { endSpan(span, err, attribute.Int64(attrTTL, ttl.Milliseconds())) }()
+
+	return r.ttl(ctx, r.countKey(key))
+}
+
+func (r *RedisStorage) Unblock(ctx context.Context, key string) (err error) {
+	ctx, span := r.startSpan(ctx, "Unblock")
+	defer func() { endSpan(span, err) }()
+
+	return r.client.Del(ctx, r.blockedKey(key)).Err()
+}
+
+// Reset deletes every counter of a key. The names of the sliding window and
+// additional quota counters are derived from quotas, so counters written
+// under other limits are left to expire.
+func (r *RedisStorage) Reset(ctx context.Context, key string, quotas []Quota) (err error) {
+	ctx, span := r.startSpan(ctx, "Reset", attribute.Int(attrQuotas, len(quotas)))
+	defer func() { endSpan(span, err) }()
+
+	keys := []string{r.countKey(key), r.bucketKey(key), r.logKey(key)}
+	for i, quota := range quotas {
+		if i == 0 {
+			// The current and previous sliding windows
+			if size := quota.Window.Milliseconds(); size > 0 {
+				current := time.Now().UnixMilli() / size
+				keys = append(keys, r.windowKey(key, current), r.windowKey(key, current-1))
+			}
+			continue
+		}
+		keys = append(keys, r.quotaKey(key, i, quota))
+	}
+
+	// Every key shares the hash tag of the rate limit key, and therefore its
+	// Redis Cluster slot
+	return r.client.Del(ctx, keys...).Err()
+}
+
+// ListBlocked finds the blocked keys with SCAN, which walks the whole
+// keyspace
+func (r *RedisStorage) ListBlocked(ctx context.Context, limit int) (blocked []BlockedKey, err error) {
+	ctx, span := r.startSpan(ctx, "ListBlocked")
+	defer func() { endSpan(span, err, attribute.Int(attrCount, len(blocked))) }()
+
+	// Blocked keys look like "<prefix>v1:blocked:{<key>}"
+	head := strings.TrimSuffix(r.blockedKey(""), "}")
+	keys, err := r.scan(ctx, escapePattern(head)+"*}", limit)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked = make([]BlockedKey, 0, len(keys))
+	for _, k := range keys {
+		ttl, err := r.ttl(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		// Skip blocks that expired since the scan
+		if ttl > 0 {
+			blocked = append(blocked, BlockedKey{Key: k[len(head) : len(k)-1], TTL: ttl})
+		}
+	}
+	return blocked, nil
+}
+
+// errScanLimit stops a scan that found enough keys
+var errScanLimit = errors.New("scan limit reached")
+
+// scan returns up to limit keys matching pattern, or all of them when limit
+// is zero or less, from every primary of a Redis Cluster
+func (r *RedisStorage) scan(ctx context.Context, pattern string, limit int) ([]string, error) {
+	var mu sync.Mutex
+	var keys []string
+
+	scanNode := func(ctx context.Context, client redis.Cmdable) error {
+		var cursor uint64
+		for {
+			batch, next, err := client.Scan(ctx, cursor, pattern, 100).Result()
+			if err != nil {
+				return err
+			}
+
+			mu.Lock()
+			keys = append(keys, batch...)
+			full := limit > 0 && len(keys) >= limit
+			mu.Unlock()
+			if full {
+				return errScanLimit
+			}
+
+			if next == 0 {
+				return nil
+			}
+			cursor = next
+		}
+	}
+
+	var err error
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return scanNode(ctx, client)
+		})
+	} else {
+		err = scanNode(ctx, r.client)
+	}
+	if err != nil && !errors.Is(err, errScanLimit) {
+		return nil, err
+	}
+
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+// escapePattern escapes the characters SCAN patterns treat specially
+func escapePattern(s string) string {
+	return patternEscaper.Replace(s)
+}
+
+var patternEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// ttl returns the time left before a Redis key expires, or zero when it does
+// not exist or has no expiration
+func (r *RedisStorage) ttl(ctx context.Context, key string) (time.Duration, error) {
+	ms, err := r.client.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// go-redis reports missing keys and keys without expiration as negative
+	// durations
+	return max(0, ms), nil
 }
 
 func (r *RedisStorage) Close() error {
 	return r.client.Close()
-}
\ No newline at end of file
+}
+
+// milliseconds converts a TTL returned by a script into a duration. Negative
+// values, which Redis uses for missing keys, become zero.
+func milliseconds(ms int64) time.Duration {
+	return time.Duration(max(0, ms)) * time.Millisecond
+}
+
+// key returns the Redis key of one kind of state of a rate limit key, such as
+// "<prefix>v1:count:{<key>}". Every Redis key derived from a rate limit key
+// wraps it in a hash tag, so that all of them map to the same Redis Cluster
+// slot and can be used together by a single script.
+func (r *RedisStorage) key(kind, key string) string {
+	return fmt.Sprintf("%sv%d:%s:{%s}", r.prefix, KeySchemaVersion, kind, key)
+}
+
+// countKey returns the request counter key
+func (r *RedisStorage) countKey(key string) string {
+	return r.key("count", key)
+}
+
+func (r *RedisStorage) blockedKey(key string) string {
+	return r.key("blocked", key)
+}
+
+func (r *RedisStorage) bucketKey(key string) string {
+	return r.key("bucket", key)
+}
+
+func (r *RedisStorage) logKey(key string) string {
+	return r.key("log", key)
+}
+
+func (r *RedisStorage) windowKey(key string, index int64) string {
+	return fmt.Sprintf("%s:%d", r.key("window", key), index)
+}
+
+// quotaKey returns the counter key of the i-th quota of a key. The first
+// quota shares its counter with IncrementRequestCount. The others are told
+// apart by position, so that two with the same window keep their own
+// counters, and by window, so that reordering them doesn't mix counters.
+func (r *RedisStorage) quotaKey(key string, i int, quota Quota) string {
+	if i == 0 {
+		return r.countKey(key)
+	}
+	return fmt.Sprintf("%s:%d:%d", r.countKey(key), i, quota.Window.Milliseconds())
+}
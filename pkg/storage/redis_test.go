@@ -2,12 +2,18 @@ package storage
 
 import (
 	"context"
-	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RedisStorageTestSuite struct {
@@ -48,6 +54,103 @@ func (s *RedisStorageTestSuite) TestNewRedisStorage() {
 	s.Require().Nil(rs)
 }
 
+func (s *RedisStorageTestSuite) TestNewRedisStorageWithOptions() {
+	rs, err := NewRedisStorageWithOptions(&redis.UniversalOptions{Addrs: []string{s.mr.Addr()}})
+	s.Require().NoError(err)
+	s.Require().NotNil(rs)
+	rs.Close()
+
+	// Sentinel options fail when no sentinel answers
+	rs, err = NewRedisStorageWithOptions(&redis.UniversalOptions{
+		Addrs:       []string{"127.0.0.1:1"},
+		MasterName:  "mymaster",
+		DialTimeout: 100 * time.Millisecond,
+	})
+	s.Require().Error(err)
+	s.Require().Nil(rs)
+}
+
+func (s *RedisStorageTestSuite) TestNewRedisStorageWithClient() {
+	client := redis.NewClient(&redis.Options{Addr: s.mr.Addr()})
+
+	rs, err := NewRedisStorageWithClient(client)
+	s.Require().NoError(err)
+
+	_, err = rs.IncrementRequestCount(s.ctx, "test-key", time.Second)
+	s.Require().NoError(err)
+	s.True(s.mr.Exists(s.rs.countKey("test-key")))
+
+	// Closing the storage closes the client
+	s.Require().NoError(rs.Close())
+	s.Error(client.Ping(s.ctx).Err())
+}
+
+func (s *RedisStorageTestSuite) TestKeysShareHashTag() {
+	key := "192.168.1.1"
+	keys := []string{
+		s.rs.countKey(key),
+		s.rs.blockedKey(key),
+		s.rs.bucketKey(key),
+		s.rs.logKey(key),
+		s.rs.windowKey(key, 1),
+		s.rs.quotaKey(key, 1, Quota{Max: 1, Window: time.Minute}),
+	}
+
+	for _, k := range keys {
+		start := strings.Index(k, "{")
+		end := strings.Index(k, "}")
+		s.Require().True(start >= 0 && end > start, k)
+		s.Equal(key, k[start+1:end], k)
+	}
+}
+
+func (s *RedisStorageTestSuite) TestKeyPrefix() {
+	rs, err := NewRedisStorage(s.mr.Addr(), "", 0, WithKeyPrefix("rl:checkout:prod:"))
+	s.Require().NoError(err)
+	defer rs.Close()
+
+	_, err = rs.IncrementRequestCount(s.ctx, "ip:10.0.0.1", time.Minute)
+	s.Require().NoError(err)
+	s.True(s.mr.Exists("rl:checkout:prod:v1:count:{ip:10.0.0.1}"))
+
+	// Storages with different prefixes don't share counters
+	count, err := s.rs.GetRequestCount(s.ctx, "ip:10.0.0.1")
+	s.Require().NoError(err)
+	s.Equal(int64(0), count)
+
+	_, err = NewRedisStorage(s.mr.Addr(), "", 0, WithKeyPrefix("rl:{checkout}:"))
+	s.Error(err)
+}
+
+func (s *RedisStorageTestSuite) TestTracerProvider() {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	rs, err := NewRedisStorage(s.mr.Addr(), "", 0, WithTracerProvider(provider))
+	s.Require().NoError(err)
+	defer rs.Close()
+
+	_, err = rs.CheckAndIncrement(s.ctx, "ip:10.0.0.1", 2, 5, time.Minute, 0)
+	s.Require().NoError(err)
+
+	spans := recorder.Ended()
+	s.Require().Len(spans, 1)
+	s.Equal("redis.CheckAndIncrement", spans[0].Name())
+	s.Equal(trace.SpanKindClient, spans[0].SpanKind())
+	s.Contains(spans[0].Attributes(), attribute.String("db.system", "redis"))
+	s.Contains(spans[0].Attributes(), attribute.Int64("ratelimiter.cost", 2))
+	s.Contains(spans[0].Attributes(), attribute.Bool("ratelimiter.allowed", true))
+	s.Contains(spans[0].Attributes(), attribute.Int64("ratelimiter.remaining", 3))
+	s.Contains(spans[0].Attributes(), attribute.Int64("ratelimiter.count", 2))
+
+	// Errors are recorded on the span
+	s.mr.Close()
+	_, err = rs.IsBlocked(s.ctx, "ip:10.0.0.1")
+	s.Require().Error(err)
+	spans = recorder.Ended()
+	s.Require().Len(spans, 2)
+	s.Equal(codes.Error, spans[1].Status().Code)
+}
+
 func (s *RedisStorageTestSuite) TestGetRequestCount() {
 	key := "test-key"
 
@@ -57,7 +160,7 @@ func (s *RedisStorageTestSuite) TestGetRequestCount() {
 	s.Equal(int64(0), count)
 
 	// Test existing key
-	s.mr.Set(fmt.Sprintf("count:%s", key), "5")
+	s.mr.Set(s.rs.countKey(key), "5")
 	count, err = s.rs.GetRequestCount(s.ctx, key)
 	s.Require().NoError(err)
 	s.Equal(int64(5), count)
@@ -78,10 +181,302 @@ func (s *RedisStorageTestSuite) TestIncrementRequestCount() {
 	s.Equal(int64(2), count)
 
 	// Verify expiration was set
-	ttl := s.mr.TTL(fmt.Sprintf("count:%s", key))
+	ttl := s.mr.TTL(s.rs.countKey(key))
+	s.True(ttl > 0)
+}
+
+func (s *RedisStorageTestSuite) TestIncrementRequestCountKeepsWindow() {
+	key := "test-key"
+
+	_, err := s.rs.IncrementRequestCount(s.ctx, key, time.Minute)
+	s.Require().NoError(err)
+
+	// Later increments must not push the expiration forward
+	s.mr.FastForward(30 * time.Second)
+	_, err = s.rs.IncrementRequestCount(s.ctx, key, time.Minute)
+	s.Require().NoError(err)
+
+	s.Equal(30*time.Second, s.mr.TTL(s.rs.countKey(key)))
+}
+
+func (s *RedisStorageTestSuite) TestIncrByWithCost() {
+	key := "test-key"
+
+	count, err := s.rs.IncrByWithCost(s.ctx, key, 5, time.Minute)
+	s.Require().NoError(err)
+	s.Equal(int64(5), count)
+
+	count, err = s.rs.IncrByWithCost(s.ctx, key, 3, time.Minute)
+	s.Require().NoError(err)
+	s.Equal(int64(8), count)
+	s.Equal(time.Minute, s.mr.TTL(s.rs.countKey(key)))
+}
+
+func (s *RedisStorageTestSuite) TestCostDeniedWhenOverRemaining() {
+	key := "test-key"
+
+	result, err := s.rs.CheckAndIncrement(s.ctx, key, 4, 5, time.Second, 0)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+	s.Equal(int64(1), result.Remaining)
+
+	// One unit is left, so a request costing two is denied
+	result, err = s.rs.CheckAndIncrement(s.ctx, key, 2, 5, time.Second, 0)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+
+	result, err = s.rs.TakeToken(s.ctx, "bucket", 3, 1, 3)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+	result, err = s.rs.TakeToken(s.ctx, "bucket", 1, 1, 3)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+
+	result, err = s.rs.SlidingWindowLog(s.ctx, "log", 2, 3, time.Second)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+	s.Equal(int64(1), result.Remaining)
+	result, err = s.rs.SlidingWindowLog(s.ctx, "log", 2, 3, time.Second)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+
+	result, err = s.rs.SlidingWindowCounter(s.ctx, "counter", 3, 3, time.Minute)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+	result, err = s.rs.SlidingWindowCounter(s.ctx, "counter", 1, 3, time.Minute)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+
+	quotas := []Quota{{Max: 10, Window: time.Second}, {Max: 4, Window: time.Minute}}
+	result, err = s.rs.IncrementQuotas(s.ctx, "quotas", 3, quotas)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+	result, err = s.rs.IncrementQuotas(s.ctx, "quotas", 2, quotas)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+	s.Equal(1, result.Quota)
+}
+
+func (s *RedisStorageTestSuite) TestCheckAndIncrement() {
+	key := "test-key"
+
+	for i := int64(1); i >= 0; i-- {
+		result, err := s.rs.CheckAndIncrement(s.ctx, key, 1, 2, time.Second, time.Minute)
+		s.Require().NoError(err)
+		s.True(result.Allowed)
+		s.False(result.Blocked)
+		s.Equal(i, result.Remaining)
+	}
+
+	// Exceeding the limit blocks the key without counting the request
+	result, err := s.rs.CheckAndIncrement(s.ctx, key, 1, 2, time.Second, time.Minute)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+	s.True(result.Blocked)
+	s.False(result.WasBlocked)
+	s.Equal(int64(2), result.Count)
+	s.True(s.mr.TTL(s.rs.blockedKey(key)) > time.Second)
+
+	// Blocked requests are no longer counted
+	result, err = s.rs.CheckAndIncrement(s.ctx, key, 1, 2, time.Second, time.Minute)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+	s.True(result.Blocked)
+	s.True(result.WasBlocked)
+
+	count, err := s.rs.GetRequestCount(s.ctx, key)
+	s.Require().NoError(err)
+	s.Equal(int64(2), count)
+}
+
+func (s *RedisStorageTestSuite) TestCheckAndIncrementWithoutBlockDuration() {
+	key := "test-key"
+
+	_, err := s.rs.CheckAndIncrement(s.ctx, key, 1, 1, time.Second, 0)
+	s.Require().NoError(err)
+
+	result, err := s.rs.CheckAndIncrement(s.ctx, key, 1, 1, time.Second, 0)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+	s.False(result.Blocked)
+	s.False(s.mr.Exists(s.rs.blockedKey(key)))
+}
+
+func (s *RedisStorageTestSuite) TestCheckAndIncrementDeniedCostIsNotCounted() {
+	key := "test-key"
+
+	result, err := s.rs.CheckAndIncrement(s.ctx, key, 5, 2, time.Second, 0)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+
+	result, err = s.rs.CheckAndIncrement(s.ctx, key, 2, 2, time.Second, 0)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+	s.Equal(int64(2), result.Count)
+}
+
+func (s *RedisStorageTestSuite) TestTakeToken() {
+	key := "test-key"
+
+	// A new bucket starts full and allows a burst up to its capacity
+	for i := int64(2); i >= 0; i-- {
+		result, err := s.rs.TakeToken(s.ctx, key, 1, 1, 3)
+		s.Require().NoError(err)
+		s.True(result.Allowed)
+		s.Equal(i, result.Remaining)
+	}
+
+	// The bucket is now empty
+	result, err := s.rs.TakeToken(s.ctx, key, 1, 1, 3)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+	s.Equal(int64(0), result.Remaining)
+
+	// Verify expiration was set
+	ttl := s.mr.TTL(s.rs.bucketKey(key))
 	s.True(ttl > 0)
 }
 
+func (s *RedisStorageTestSuite) TestTakeTokenRefill() {
+	key := "refill-key"
+
+	result, err := s.rs.TakeToken(s.ctx, key, 1, 100, 1)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+
+	result, err = s.rs.TakeToken(s.ctx, key, 1, 100, 1)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+
+	// At 100 tokens per second a token is back after 10ms
+	time.Sleep(20 * time.Millisecond)
+
+	result, err = s.rs.TakeToken(s.ctx, key, 1, 100, 1)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+}
+
+func (s *RedisStorageTestSuite) TestSlidingWindowLog() {
+	key := "test-key"
+	window := 100 * time.Millisecond
+
+	for i := int64(1); i >= 0; i-- {
+		result, err := s.rs.SlidingWindowLog(s.ctx, key, 1, 2, window)
+		s.Require().NoError(err)
+		s.True(result.Allowed)
+		s.Equal(i, result.Remaining)
+	}
+
+	// Denied requests are not recorded
+	result, err := s.rs.SlidingWindowLog(s.ctx, key, 1, 2, window)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+
+	members, err := s.mr.ZMembers(s.rs.logKey(key))
+	s.Require().NoError(err)
+	s.Len(members, 2)
+
+	// Once the recorded requests leave the window there is room again
+	time.Sleep(window + 10*time.Millisecond)
+
+	result, err = s.rs.SlidingWindowLog(s.ctx, key, 1, 2, window)
+	s.Require().NoError(err)
+	s.True(result.Allowed)
+}
+
+func (s *RedisStorageTestSuite) TestSlidingWindowCounter() {
+	key := "test-key"
+	window := time.Minute
+
+	for i := 0; i < 3; i++ {
+		result, err := s.rs.SlidingWindowCounter(s.ctx, key, 1, 3, window)
+		s.Require().NoError(err)
+		s.True(result.Allowed)
+	}
+
+	result, err := s.rs.SlidingWindowCounter(s.ctx, key, 1, 3, window)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+	s.Equal(int64(0), result.Remaining)
+}
+
+func (s *RedisStorageTestSuite) TestSlidingWindowCounterRejectsSubMillisecondWindow() {
+	_, err := s.rs.SlidingWindowCounter(s.ctx, "test-key", 1, 3, 500*time.Microsecond)
+	s.Error(err)
+}
+
+func (s *RedisStorageTestSuite) TestSlidingWindowCounterWeightsPreviousWindow() {
+	key := "test-key"
+	window := time.Minute
+	previous := time.Now().UnixMilli()/window.Milliseconds() - 1
+
+	// A full previous window still counts for the part that overlaps the trailing window
+	s.mr.Set(s.rs.windowKey(key, previous), "1000000")
+
+	result, err := s.rs.SlidingWindowCounter(s.ctx, key, 1, 10, window)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+}
+
+func (s *RedisStorageTestSuite) TestIncrementQuotas() {
+	key := "test-key"
+	quotas := []Quota{
+		{Max: 5, Window: time.Second},
+		{Max: 2, Window: time.Minute},
+	}
+
+	// Every counter is incremented while all quotas have room
+	for i := int64(1); i >= 0; i-- {
+		result, err := s.rs.IncrementQuotas(s.ctx, key, 1, quotas)
+		s.Require().NoError(err)
+		s.True(result.Allowed)
+		s.Equal(i, result.Remaining)
+	}
+
+	// The per-minute quota is exhausted and no counter is incremented
+	result, err := s.rs.IncrementQuotas(s.ctx, key, 1, quotas)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+	s.Equal(1, result.Quota)
+
+	count, err := s.rs.GetRequestCount(s.ctx, key)
+	s.Require().NoError(err)
+	s.Equal(int64(2), count)
+
+	minuteKey := s.rs.quotaKey(key, 1, quotas[1])
+	value, err := s.mr.Get(minuteKey)
+	s.Require().NoError(err)
+	s.Equal("2", value)
+	s.True(s.mr.TTL(minuteKey) > time.Second)
+}
+
+func (s *RedisStorageTestSuite) TestIncrementQuotasWithSameWindow() {
+	key := "test-key"
+	quotas := []Quota{
+		{Max: 10, Window: time.Second},
+		{Max: 3, Window: time.Minute},
+		{Max: 5, Window: time.Minute},
+	}
+
+	// Each quota counts every request once
+	for i := 0; i < 3; i++ {
+		result, err := s.rs.IncrementQuotas(s.ctx, key, 1, quotas)
+		s.Require().NoError(err)
+		s.True(result.Allowed)
+	}
+
+	result, err := s.rs.IncrementQuotas(s.ctx, key, 1, quotas)
+	s.Require().NoError(err)
+	s.False(result.Allowed)
+	s.Equal(1, result.Quota)
+
+	for i := 1; i < len(quotas); i++ {
+		value, err := s.mr.Get(s.rs.quotaKey(key, i, quotas[i]))
+		s.Require().NoError(err)
+		s.Equal("3", value)
+	}
+}
+
 func (s *RedisStorageTestSuite) TestIsBlocked() {
 	key := "test-key"
 
@@ -91,7 +486,7 @@ func (s *RedisStorageTestSuite) TestIsBlocked() {
 	s.False(blocked)
 
 	// Test blocked key
-	s.mr.Set(fmt.Sprintf("blocked:%s", key), "1")
+	s.mr.Set(s.rs.blockedKey(key), "1")
 	blocked, err = s.rs.IsBlocked(s.ctx, key)
 	s.Require().NoError(err)
 	s.True(blocked)
@@ -111,10 +506,112 @@ func (s *RedisStorageTestSuite) TestBlock() {
 	s.True(blocked)
 
 	// Verify expiration was set
-	ttl := s.mr.TTL(fmt.Sprintf("blocked:%s", key))
+	ttl := s.mr.TTL(s.rs.blockedKey(key))
 	s.True(ttl > 0)
 }
 
+func (s *RedisStorageTestSuite) TestGetBlockTTL() {
+	key := "test-key"
+
+	ttl, err := s.rs.GetBlockTTL(s.ctx, key)
+	s.Require().NoError(err)
+	s.Zero(ttl)
+
+	s.Require().NoError(s.rs.Block(s.ctx, key, time.Minute))
+	s.mr.FastForward(10 * time.Second)
+
+	ttl, err = s.rs.GetBlockTTL(s.ctx, key)
+	s.Require().NoError(err)
+	s.Equal(50*time.Second, ttl)
+}
+
+func (s *RedisStorageTestSuite) TestGetWindowTTL() {
+	key := "test-key"
+
+	ttl, err := s.rs.GetWindowTTL(s.ctx, key)
+	s.Require().NoError(err)
+	s.Zero(ttl)
+
+	_, err = s.rs.IncrementRequestCount(s.ctx, key, time.Minute)
+	s.Require().NoError(err)
+	s.mr.FastForward(15 * time.Second)
+
+	ttl, err = s.rs.GetWindowTTL(s.ctx, key)
+	s.Require().NoError(err)
+	s.Equal(45*time.Second, ttl)
+}
+
+func (s *RedisStorageTestSuite) TestUnblock() {
+	key := "ip:10.0.0.1"
+	s.Require().NoError(s.rs.Block(s.ctx, key, time.Minute))
+
+	s.Require().NoError(s.rs.Unblock(s.ctx, key))
+	blocked, err := s.rs.IsBlocked(s.ctx, key)
+	s.Require().NoError(err)
+	s.False(blocked)
+
+	// Unblocking a key that isn't blocked is not an error
+	s.NoError(s.rs.Unblock(s.ctx, key))
+}
+
+func (s *RedisStorageTestSuite) TestReset() {
+	key := "ip:10.0.0.1"
+	other := "ip:10.0.0.10"
+	quotas := []Quota{{Max: 10, Window: time.Minute}, {Max: 100, Window: time.Hour}}
+
+	for _, k := range []string{key, other} {
+		_, err := s.rs.IncrementQuotas(s.ctx, k, 1, quotas)
+		s.Require().NoError(err)
+		_, err = s.rs.TakeToken(s.ctx, k, 1, 1, 10)
+		s.Require().NoError(err)
+		_, err = s.rs.SlidingWindowLog(s.ctx, k, 1, 10, time.Minute)
+		s.Require().NoError(err)
+		_, err = s.rs.SlidingWindowCounter(s.ctx, k, 1, 10, time.Minute)
+		s.Require().NoError(err)
+	}
+	previous := time.Now().UnixMilli()/time.Minute.Milliseconds() - 1
+	s.Require().NoError(s.mr.Set(s.rs.windowKey(key, previous), "5"))
+	s.Require().NoError(s.rs.Block(s.ctx, key, time.Minute))
+
+	s.Require().NoError(s.rs.Reset(s.ctx, key, quotas))
+
+	// Only the block of the key is left, and other keys are untouched
+	var left []string
+	for _, k := range s.mr.Keys() {
+		if strings.Contains(k, "{"+key+"}") {
+			left = append(left, k)
+		}
+	}
+	s.Equal([]string{s.rs.blockedKey(key)}, left)
+
+	count, err := s.rs.GetRequestCount(s.ctx, other)
+	s.Require().NoError(err)
+	s.Equal(int64(1), count)
+}
+
+func (s *RedisStorageTestSuite) TestListBlocked() {
+	rs, err := NewRedisStorage(s.mr.Addr(), "", 0, WithKeyPrefix("rl:*:"))
+	s.Require().NoError(err)
+	defer rs.Close()
+
+	s.Require().NoError(rs.Block(s.ctx, "ip:10.0.0.1", time.Minute))
+	s.Require().NoError(rs.Block(s.ctx, "token/checkout:abc", 2*time.Minute))
+	s.Require().NoError(s.rs.Block(s.ctx, "ip:10.0.0.2", time.Minute)) // without prefix
+	_, err = rs.IncrementRequestCount(s.ctx, "ip:10.0.0.3", time.Minute)
+	s.Require().NoError(err)
+
+	blocked, err := rs.ListBlocked(s.ctx, 0)
+	s.Require().NoError(err)
+	s.ElementsMatch([]BlockedKey{
+		{Key: "ip:10.0.0.1", TTL: time.Minute},
+		{Key: "token/checkout:abc", TTL: 2 * time.Minute},
+	}, blocked)
+
+	blocked, err = rs.ListBlocked(s.ctx, 1)
+	s.Require().NoError(err)
+	s.Len(blocked, 1)
+}
+
 func TestRedisStorageTestSuite(t *testing.T) {
 	suite.Run(t, new(RedisStorageTestSuite))
 }
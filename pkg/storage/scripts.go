@@ -0,0 +1,226 @@
+package storage
+
+import "github.com/redis/go-redis/v9"
+
+// incrementScript increments a counter by a cost and starts its expiration on
+// the first increment only.
+//
+// KEYS[1] counter key
+// ARGV[1] expiration in milliseconds
+// ARGV[2] cost
+//
+// Returns the new count.
+var incrementScript = redis.NewScript(`
+local count = redis.call('INCRBY', KEYS[1], ARGV[2])
+if redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// checkAndIncrementScript performs the whole fixed-window check in one round
+// trip: block check, limit check, increment, window expiration and blocking.
+// Denied requests are not counted.
+//
+// KEYS[1] counter key
+// KEYS[2] block key
+// ARGV[1] limit
+// ARGV[2] window in milliseconds
+// ARGV[3] block duration in milliseconds
+// ARGV[4] cost
+//
+// Returns {allowed (0|1), blocked (0|1), count, window ttl, block ttl} with
+// both ttls in milliseconds. The count includes the request only when it is
+// allowed; it is not read when the key was already blocked and is returned as
+// zero.
+var checkAndIncrementScript = redis.NewScript(`
+-- PTTL is -2 for missing keys and -1 for blocks without expiration
+local blocked = redis.call('PTTL', KEYS[2])
+if blocked ~= -2 then
+	return {0, 1, 0, redis.call('PTTL', KEYS[1]), blocked}
+end
+
+local limit = tonumber(ARGV[1])
+local cost = tonumber(ARGV[4])
+local count = tonumber(redis.call('GET', KEYS[1]) or '0')
+if count + cost > limit then
+	local ttl = math.max(0, redis.call('PTTL', KEYS[1]))
+	local block = tonumber(ARGV[3])
+	if block > 0 then
+		redis.call('SET', KEYS[2], 1, 'PX', block)
+		return {0, 1, count, ttl, block}
+	end
+	return {0, 0, count, ttl, 0}
+end
+
+count = redis.call('INCRBY', KEYS[1], cost)
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	ttl = tonumber(ARGV[2])
+end
+
+return {1, 0, count, ttl, 0}
+`)
+
+// tokenBucketScript refills and consumes a token bucket stored as a hash.
+//
+// KEYS[1] bucket key
+// ARGV[1] refill rate in tokens per second
+// ARGV[2] bucket capacity
+// ARGV[3] current time in milliseconds
+// ARGV[4] tokens to take
+//
+// Returns {allowed (0|1), remaining tokens, milliseconds until the bucket is full}.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+
+local elapsed = math.max(0, now - ts)
+tokens = math.min(capacity, tokens + elapsed * rate / 1000)
+
+local allowed = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+
+-- Keep the bucket only for as long as it takes to refill completely
+local full = 0
+if rate > 0 then
+	full = math.ceil((capacity - tokens) / rate * 1000)
+end
+redis.call('PEXPIRE', KEYS[1], full + 1000)
+
+return {allowed, math.floor(tokens), full}
+`)
+
+// slidingWindowLogScript keeps one sorted set member per request scored by
+// its timestamp and only records a request when the trailing window has room.
+//
+// KEYS[1] log key
+// ARGV[1] limit
+// ARGV[2] window in milliseconds
+// ARGV[3] current time in milliseconds
+// ARGV[4] unique member prefix for this request
+// ARGV[5] cost, the number of members recorded for the request
+//
+// Returns {allowed (0|1), remaining requests, milliseconds until the oldest
+// request leaves the window}.
+var slidingWindowLogScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local cost = tonumber(ARGV[5])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+
+local allowed = 0
+if count + cost <= limit then
+	for i = 1, cost do
+		redis.call('ZADD', KEYS[1], now, ARGV[4] .. '-' .. i)
+	end
+	count = count + cost
+	allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], window)
+
+local reset = window
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+
+return {allowed, math.max(0, limit - count), reset}
+`)
+
+// slidingWindowCounterScript weights the previous fixed window's count by its
+// overlap with the trailing window and adds the current window's count.
+//
+// KEYS[1] current window counter
+// KEYS[2] previous window counter
+// ARGV[1] limit
+// ARGV[2] window in milliseconds
+// ARGV[3] milliseconds elapsed in the current window
+// ARGV[4] cost
+//
+// Returns {allowed (0|1), remaining requests, milliseconds until the current
+// window ends}.
+var slidingWindowCounterScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local elapsed = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local previous = tonumber(redis.call('GET', KEYS[2]) or '0')
+local estimate = previous * (window - elapsed) / window + current
+
+local allowed = 0
+if estimate + cost <= limit then
+	current = redis.call('INCRBY', KEYS[1], cost)
+	if redis.call('PTTL', KEYS[1]) < 0 then
+		-- The counter is read again as the previous window, so keep it for two
+		redis.call('PEXPIRE', KEYS[1], window * 2)
+	end
+	estimate = estimate + cost
+	allowed = 1
+end
+
+return {allowed, math.max(0, math.floor(limit - estimate)), window - elapsed}
+`)
+
+// quotasScript checks a set of fixed-window counters and increments all of
+// them only if every quota still has room.
+//
+// KEYS[i]       counter of the i-th quota
+// ARGV[1]       cost
+// ARGV[2i]      maximum requests of the i-th quota
+// ARGV[2i + 1]  window of the i-th quota in milliseconds
+//
+// Returns {allowed (0|1), quota index, remaining requests, milliseconds until
+// the quota resets}, where the quota is the exceeded one, or the one with the
+// fewest remaining requests when allowed.
+var quotasScript = redis.NewScript(`
+local cost = tonumber(ARGV[1])
+for i = 1, #KEYS do
+	local max = tonumber(ARGV[2 * i])
+	local count = tonumber(redis.call('GET', KEYS[i]) or '0')
+	if count + cost > max then
+		return {0, i - 1, 0, redis.call('PTTL', KEYS[i])}
+	end
+end
+
+local quota = 0
+local remaining = -1
+local reset = 0
+for i = 1, #KEYS do
+	local max = tonumber(ARGV[2 * i])
+	local count = redis.call('INCRBY', KEYS[i], cost)
+	local ttl = redis.call('PTTL', KEYS[i])
+	if ttl < 0 then
+		redis.call('PEXPIRE', KEYS[i], ARGV[2 * i + 1])
+		ttl = tonumber(ARGV[2 * i + 1])
+	end
+	if remaining < 0 or max - count < remaining then
+		quota = i - 1
+		remaining = max - count
+		reset = ttl
+	end
+end
+
+return {1, quota, remaining, reset}
+`)
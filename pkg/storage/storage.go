@@ -5,20 +5,124 @@ import (
 	"time"
 )
 
+// Result is the outcome of consuming a request from a rate limiting algorithm
+type Result struct {
+	// Allowed reports whether the request fits within the limit
+	Allowed bool
+
+	// Remaining is the number of requests still available after this one
+	Remaining int64
+
+	// Count is the number of requests counted in the key's fixed window,
+	// including this one when it is allowed. Only CheckAndIncrement reports
+	// it; it is zero for the other algorithms and for keys that were already
+	// blocked.
+	Count int64
+
+	// ResetAfter is the time until the limit resets: the end of the current
+	// window, or when the token bucket is full again
+	ResetAfter time.Duration
+
+	// Blocked reports whether the key is blocked, either because it already
+	// was or because this request exceeded the limit
+	Blocked bool
+
+	// WasBlocked reports whether the key was already blocked before this
+	// request, which was therefore not counted
+	WasBlocked bool
+
+	// BlockTTL is the time left on the key's block, zero when not blocked
+	BlockTTL time.Duration
+
+	// Quota is the index of the quota the result describes when several
+	// quotas are checked at once: the one that denied the request, or the one
+	// with the fewest remaining requests when it was allowed
+	Quota int
+}
+
+// BlockedKey is a key listed by ListBlocked
+type BlockedKey struct {
+	Key string
+
+	// TTL is the time left on the key's block
+	TTL time.Duration
+}
+
+// Quota limits a key to Max requests per Window
+type Quota struct {
+	Max    int64
+	Window time.Duration
+}
+
 // Storage defines the interface for rate limiter storage implementations
 type Storage interface {
 	// GetRequestCount returns the current request count for a key
 	GetRequestCount(ctx context.Context, key string) (int64, error)
 
-	// IncrementRequestCount increments the request count for a key
+	// IncrementRequestCount increments the request count for a key. The
+	// expiration is only set when the count is created, so the window does
+	// not move while requests keep coming.
 	IncrementRequestCount(ctx context.Context, key string, expiration time.Duration) (int64, error)
 
+	// IncrByWithCost increments the request count for a key by cost, like
+	// IncrementRequestCount does by one
+	IncrByWithCost(ctx context.Context, key string, cost int64, expiration time.Duration) (int64, error)
+
+	// The methods below consume cost units from a limit at once, one for a
+	// plain request. A request is only allowed when all of its units fit.
+
+	// CheckAndIncrement atomically checks whether a key is blocked and, if it
+	// is not, increments its fixed-window count by cost when the cost fits
+	// within limit. Otherwise the request is denied without being counted
+	// and the key is blocked for blockDuration.
+	CheckAndIncrement(ctx context.Context, key string, cost, limit int64, window, blockDuration time.Duration) (Result, error)
+
+	// TakeToken atomically refills the token bucket for a key at rate tokens
+	// per second, up to capacity, and takes cost tokens from it if available
+	TakeToken(ctx context.Context, key string, cost int64, rate float64, capacity int64) (Result, error)
+
+	// SlidingWindowLog records cost requests for a key if they fit within
+	// limit together with the requests recorded during the trailing window
+	SlidingWindowLog(ctx context.Context, key string, cost, limit int64, window time.Duration) (Result, error)
+
+	// SlidingWindowCounter counts cost requests for a key if the current
+	// window's count plus the overlapping share of the previous window's
+	// count stays within limit
+	SlidingWindowCounter(ctx context.Context, key string, cost, limit int64, window time.Duration) (Result, error)
+
+	// IncrementQuotas atomically checks every quota for a key and, only when
+	// none of them would be exceeded, increments all of their fixed-window
+	// counters by cost. The first quota shares its counter with
+	// IncrementRequestCount.
+	IncrementQuotas(ctx context.Context, key string, cost int64, quotas []Quota) (Result, error)
+
 	// IsBlocked checks if a key is currently blocked
 	IsBlocked(ctx context.Context, key string) (bool, error)
 
 	// Block sets a block on a key for the specified duration
 	Block(ctx context.Context, key string, duration time.Duration) error
 
+	// GetBlockTTL returns the time left on a key's block, or zero when the
+	// key is not blocked
+	GetBlockTTL(ctx context.Context, key string) (time.Duration, error)
+
+	// GetWindowTTL returns the time left in a key's current fixed window,
+	// the one counted by IncrementRequestCount, or zero when there is none
+	GetWindowTTL(ctx context.Context, key string) (time.Duration, error)
+
+	// Unblock lifts the block on a key, if any
+	Unblock(ctx context.Context, key string) error
+
+	// Reset discards the state every algorithm keeps for a key, so that its
+	// limits start over. Its block, if any, is kept. The quotas are the
+	// key's limits as passed to IncrementQuotas, the first one giving the
+	// window of the sliding window counter.
+	Reset(ctx context.Context, key string, quotas []Quota) error
+
+	// ListBlocked returns up to limit blocked keys, or all of them when limit
+	// is zero or less, in no particular order
+	ListBlocked(ctx context.Context, limit int) ([]BlockedKey, error)
+
 	// Close closes the storage connection
 	Close() error
-}
\ No newline at end of file
+}
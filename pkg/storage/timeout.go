@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// TimeoutStorage bounds every call to another Storage by a timeout, so that
+// a storage that hangs fails requests quickly instead of holding them
+type TimeoutStorage struct {
+	backend Storage
+	timeout time.Duration
+}
+
+// NewTimeoutStorage wraps backend so that each call is cancelled after
+// timeout. Calls already bound by an earlier deadline keep it.
+//
+// Redis clients only give up on a call when ContextTimeoutEnabled is set in
+// their options, as NewRedisStorage and middleware.LoadRedisOptions do.
+func NewTimeoutStorage(backend Storage, timeout time.Duration) *TimeoutStorage {
+	return &TimeoutStorage{backend: backend, timeout: timeout}
+}
+
+// withTimeout returns ctx bound by the storage's timeout
+func (t *TimeoutStorage) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, t.timeout)
+}
+
+func (t *TimeoutStorage) GetRequestCount(ctx context.Context, key string) (int64, error) {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.backend.GetRequestCount(ctx, key)
+}
+
+func (t *TimeoutStorage) IncrementRequestCount(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.backend.IncrementRequestCount(ctx, key, expiration)
+}
+
+func (t *TimeoutStorage) IncrByWithCost(ctx context.Context, key string, cost int64, expiration time.Duration) (int64, error) {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.backend.IncrByWithCost(ctx, key, cost, expiration)
+}
+
+func (t *TimeoutStorage) CheckAndIncrement(ctx context.Context, key string, cost, limit int64, window, blockDuration time.Duration) (Result, error) {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.backend.CheckAndIncrement(ctx, key, cost, limit, window, blockDuration)
+}
+
+func (t *TimeoutStorage) TakeToken(ctx context.Context, key string, cost int64, rate float64, capacity int64) (Result, error) {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.backend.TakeToken(ctx, key, cost, rate, capacity)
+}
+
+func (t *TimeoutStorage) SlidingWindowLog(ctx context.Context, key string, cost, limit int64, window time.Duration) (Result, error) {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.backend.SlidingWindowLog(ctx, key, cost, limit, window)
+}
+
+func (t *TimeoutStorage) SlidingWindowCounter(ctx context.Context, key string, cost, limit int64, window time.Duration) (Result, error) {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.backend.SlidingWindowCounter(ctx, key, cost, limit, window)
+}
+
+func (t *TimeoutStorage) IncrementQuotas(ctx context.Context, key string, cost int64, quotas []Quota) (Result, error) {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.backend.IncrementQuotas(ctx, key, cost, quotas)
+}
+
+func (t *TimeoutStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.backend.IsBlocked(ctx, key)
+}
+
+func (t *TimeoutStorage) Block(ctx context.Context, key string, duration time.Duration) error {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.backend.Block(ctx, key, duration)
+}
+
+func (t *TimeoutStorage) GetBlockTTL(ctx context.Context, key string) (time.Duration, error) {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.backend.GetBlockTTL(ctx, key)
+}
+
+func (t *TimeoutStorage) GetWindowTTL(ctx context.Context, key string) (time.Duration, error) {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.backend.GetWindowTTL(ctx, key)
+}
+
+func (t *TimeoutStorage) Unblock(ctx context.Context, key string) error {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.backend.Unblock(ctx, key)
+}
+
+func (t *TimeoutStorage) Reset(ctx context.Context, key string, quotas []Quota) error {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.backend.Reset(ctx, key, quotas)
+}
+
+func (t *TimeoutStorage) ListBlocked(ctx context.Context, limit int) ([]BlockedKey, error) {
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	return t.backend.ListBlocked(ctx, limit)
+}
+
+func (t *TimeoutStorage) Close() error {
+	return t.backend.Close()
+}
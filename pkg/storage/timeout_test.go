@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// slowStorage is a MemoryStorage whose CheckAndIncrement takes delay
+type slowStorage struct {
+	*MemoryStorage
+	delay time.Duration
+}
+
+func (s slowStorage) CheckAndIncrement(ctx context.Context, key string, cost, limit int64, window, blockDuration time.Duration) (Result, error) {
+	select {
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	case <-time.After(s.delay):
+		return s.MemoryStorage.CheckAndIncrement(ctx, key, cost, limit, window, blockDuration)
+	}
+}
+
+func TestTimeoutStorage(t *testing.T) {
+	backend := slowStorage{MemoryStorage: NewMemoryStorage(MemoryOptions{}), delay: time.Second}
+	store := NewTimeoutStorage(backend, 20*time.Millisecond)
+	defer store.Close()
+
+	start := time.Now()
+	_, err := store.CheckAndIncrement(context.Background(), "key", 1, 10, time.Second, 0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("CheckAndIncrement() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("CheckAndIncrement() took %v, want it cut short by the timeout", elapsed)
+	}
+
+	// Fast calls are unaffected
+	count, err := store.IncrementRequestCount(context.Background(), "key", time.Second)
+	if err != nil || count != 1 {
+		t.Errorf("IncrementRequestCount() = %d, %v, want 1, nil", count, err)
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the storage spans
+const tracerName = "github.com/fabiohsgomes/go-expert-desafiostec-ratelimiter/pkg/storage"
+
+// Span attributes. Keys are left out, since they may hold API tokens.
+const (
+	attrCost      = "ratelimiter.cost"
+	attrLimit     = "ratelimiter.limit"
+	attrQuotas    = "ratelimiter.quotas"
+	attrCount     = "ratelimiter.count"
+	attrAllowed   = "ratelimiter.allowed"
+	attrRemaining = "ratelimiter.remaining"
+	attrBlocked   = "ratelimiter.blocked"
+	attrDuration  = "ratelimiter.block_duration_ms"
+	attrTTL       = "ratelimiter.ttl_ms"
+)
+
+// noopSpan is returned when tracing is disabled
+var noopSpan = trace.SpanFromContext(context.Background())
+
+// WithTracerProvider makes the storage create a span for each call, named
+// after the method, such as "redis.CheckAndIncrement". Without it, no spans
+// are created.
+func WithTracerProvider(provider trace.TracerProvider) RedisOption {
+	return func(r *RedisStorage) {
+		r.tracer = provider.Tracer(tracerName)
+	}
+}
+
+// startSpan starts the span of a call to a Redis storage method. Without a
+// tracer, ctx is returned as is with a span that records nothing.
+func (r *RedisStorage) startSpan(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if r.tracer == nil {
+		return ctx, noopSpan
+	}
+	attrs = append(attrs, attribute.String("db.system", "redis"))
+	return r.tracer.Start(ctx, "redis."+method,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endSpan ends a span with the outcome of its call
+func endSpan(span trace.Span, err error, attrs ...attribute.KeyValue) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attrs...)
+	}
+	span.End()
+}
+
+// resultAttributes describes a Result as span attributes
+func resultAttributes(result Result) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Bool(attrAllowed, result.Allowed),
+		attribute.Int64(attrRemaining, result.Remaining),
+		attribute.Int64(attrCount, result.Count),
+		attribute.Bool(attrBlocked, result.Blocked),
+	}
+}